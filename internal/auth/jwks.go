@@ -0,0 +1,210 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// minRefetch limits how often an unknown key ID can trigger a reload, so
+// tokens with made-up key IDs can't hammer the identity provider
+const minRefetch = time.Minute
+
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// jwk is a single JSON Web Key as published in a JWKS document
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// signingKey is a parsed public key and the algorithm it may be used with
+type signingKey struct {
+	alg string
+	key crypto.PublicKey
+}
+
+// KeySet is a cached JWKS loaded from a file or an http(s) URL. It reloads
+// every refresh interval, and early when a token names a key it hasn't seen,
+// which is how rotated keys get picked up.
+type KeySet struct {
+	source  string
+	refresh time.Duration
+	client  *http.Client
+
+	mu        sync.RWMutex
+	keys      map[string]signingKey
+	fetchedAt time.Time
+}
+
+// NewKeySet loads the JWKS at source, which is a file path or an http(s) URL
+func NewKeySet(ctx context.Context, source string, refresh time.Duration) (*KeySet, error) {
+	set := &KeySet{
+		source:  source,
+		refresh: refresh,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := set.reload(ctx); err != nil {
+		return nil, err
+	}
+	return set, nil
+}
+
+// Key returns the key with the given ID, reloading the set if it is stale or
+// doesn't know the ID yet
+func (s *KeySet) Key(ctx context.Context, kid string) (signingKey, error) {
+	s.mu.RLock()
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetchedAt)
+	s.mu.RUnlock()
+
+	if (ok && age < s.refresh) || (!ok && age < minRefetch) {
+		if !ok {
+			return signingKey{}, ErrUnknownKey
+		}
+		return key, nil
+	}
+
+	if err := s.reload(ctx); err != nil {
+		// Keep verifying with the keys we have while the provider is unreachable
+		log.Printf("Error reloading JWKS from %s: %v", s.source, err)
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	return signingKey{}, ErrUnknownKey
+}
+
+func (s *KeySet) reload(ctx context.Context) error {
+	raw, err := s.fetch(ctx)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Failed loads count too, so an outage isn't retried on every request
+	s.fetchedAt = time.Now()
+	if err != nil {
+		return err
+	}
+
+	var doc struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(raw, &doc); err != nil {
+		return fmt.Errorf("invalid JWKS: %w", err)
+	}
+
+	keys := make(map[string]signingKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := parseJWK(k)
+		if err != nil {
+			log.Printf("Skipping JWKS key %q: %v", k.Kid, err)
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return errors.New("JWKS has no usable signing keys")
+	}
+	s.keys = keys
+	return nil
+}
+
+func (s *KeySet) fetch(ctx context.Context) ([]byte, error) {
+	if !strings.HasPrefix(s.source, "http://") && !strings.HasPrefix(s.source, "https://") {
+		return os.ReadFile(s.source)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.source, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("JWKS request returned %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+}
+
+// parseJWK turns an RSA or P-256 key into a key usable for RS256 or ES256
+func parseJWK(k jwk) (signingKey, error) {
+	switch k.Kty {
+	case "RSA":
+		if k.Alg != "" && k.Alg != "RS256" {
+			return signingKey{}, fmt.Errorf("unsupported algorithm %s", k.Alg)
+		}
+		n, err := encoding.DecodeString(k.N)
+		if err != nil {
+			return signingKey{}, err
+		}
+		e, err := encoding.DecodeString(k.E)
+		if err != nil {
+			return signingKey{}, err
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if len(n) < 256 || !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31 {
+			return signingKey{}, errors.New("invalid RSA key")
+		}
+		return signingKey{alg: "RS256", key: &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(exponent.Int64()),
+		}}, nil
+
+	case "EC":
+		if k.Crv != "P-256" || (k.Alg != "" && k.Alg != "ES256") {
+			return signingKey{}, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := encoding.DecodeString(k.X)
+		if err != nil {
+			return signingKey{}, err
+		}
+		y, err := encoding.DecodeString(k.Y)
+		if err != nil {
+			return signingKey{}, err
+		}
+		if len(x) != 32 || len(y) != 32 {
+			return signingKey{}, errors.New("invalid P-256 key")
+		}
+		// Parsing the uncompressed point checks that it lies on the curve
+		point := append(append([]byte{4}, x...), y...)
+		if _, err := ecdh.P256().NewPublicKey(point); err != nil {
+			return signingKey{}, err
+		}
+		return signingKey{alg: "ES256", key: &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}}, nil
+	}
+	return signingKey{}, fmt.Errorf("unsupported key type %s", k.Kty)
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"chatsystem/internal/config"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// audience accepts the aud claim as either a string or a list of strings
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// OIDCClaims are the identity provider claims we check
+type OIDCClaims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	IssuedAt  int64    `json:"iat"`
+	NotBefore int64    `json:"nbf"`
+	ExpiresAt int64    `json:"exp"`
+	Tenant    string   `json:"-"` // Read from the claim named by tenantClaim
+}
+
+// OIDCVerifier checks RS256 and ES256 tokens from an identity provider
+type OIDCVerifier struct {
+	keys        *KeySet
+	issuer      string
+	audience    string
+	tenantClaim string
+}
+
+func NewOIDCVerifier(keys *KeySet, issuer, audience, tenantClaim string) *OIDCVerifier {
+	return &OIDCVerifier{
+		keys:        keys,
+		issuer:      issuer,
+		audience:    audience,
+		tenantClaim: tenantClaim,
+	}
+}
+
+// Verify checks a token's signature against the JWKS and its issuer,
+// audience and lifetime, and returns its claims
+func (v *OIDCVerifier) Verify(ctx context.Context, token string, now time.Time) (*OIDCClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var head struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &head); err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, err := v.keys.Key(ctx, head.Kid)
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	// The key decides the algorithm, never the token
+	if head.Alg != key.alg {
+		return nil, ErrInvalidToken
+	}
+
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if !verifySignature(key, digest[:], sig) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims OIDCClaims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return nil, ErrInvalidToken
+	}
+	if claims.Issuer != v.issuer || !claims.hasAudience(v.audience) {
+		return nil, ErrInvalidToken
+	}
+	if now.Add(-clockSkew).Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Unix() < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+
+	if v.tenantClaim != "" {
+		var extra map[string]interface{}
+		if err := decodeSegment(parts[1], &extra); err != nil {
+			return nil, ErrInvalidToken
+		}
+		if tenant, present := extra[v.tenantClaim]; present {
+			name, ok := tenant.(string)
+			if !ok {
+				return nil, ErrInvalidToken
+			}
+			claims.Tenant = name
+		}
+	}
+	return &claims, nil
+}
+
+func (c *OIDCClaims) hasAudience(want string) bool {
+	for _, aud := range c.Audience {
+		if aud == want {
+			return true
+		}
+	}
+	return false
+}
+
+func verifySignature(key signingKey, digest, sig []byte) bool {
+	switch pub := key.key.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		// JWS encodes ES256 signatures as fixed width r || s
+		if len(sig) != 64 {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:32])
+		s := new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, digest, r, s)
+	}
+	return false
+}
+
+// oidc verifies identity provider tokens, nil unless OIDC_JWKS is configured
+var oidc *OIDCVerifier
+
+// ConnectOIDC loads the identity provider's JWKS when OIDC_JWKS is set
+func ConnectOIDC(ctx context.Context) error {
+	if config.AppConfig.OIDCJWKS == "" {
+		return nil
+	}
+	keys, err := NewKeySet(ctx, config.AppConfig.OIDCJWKS, config.AppConfig.OIDCJWKSRefresh)
+	if err != nil {
+		return err
+	}
+	oidc = NewOIDCVerifier(keys, config.AppConfig.OIDCIssuer, config.AppConfig.OIDCAudience, config.AppConfig.OIDCTenantClaim)
+	log.Println("🔑 \033[1;32mOIDC Verifier ::Ready\033[0m")
+	return nil
+}
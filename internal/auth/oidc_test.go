@@ -0,0 +1,256 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const (
+	testIssuer   = "https://id.example.com"
+	testAudience = "chat"
+)
+
+// testSigner is a private key published in a test JWKS under kid
+type testSigner struct {
+	kid string
+	alg string
+	key crypto.Signer
+}
+
+func newRSASigner(t *testing.T, kid string) testSigner {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating RSA key: %v", err)
+	}
+	return testSigner{kid: kid, alg: "RS256", key: key}
+}
+
+func newECSigner(t *testing.T, kid string) testSigner {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("generating P-256 key: %v", err)
+	}
+	return testSigner{kid: kid, alg: "ES256", key: key}
+}
+
+func (s testSigner) jwk() jwk {
+	switch pub := s.key.Public().(type) {
+	case *rsa.PublicKey:
+		return jwk{
+			Kty: "RSA", Kid: s.kid, Use: "sig", Alg: "RS256",
+			N: encoding.EncodeToString(pub.N.Bytes()),
+			E: encoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case *ecdsa.PublicKey:
+		return jwk{
+			Kty: "EC", Kid: s.kid, Use: "sig", Crv: "P-256",
+			X: encoding.EncodeToString(pub.X.FillBytes(make([]byte, 32))),
+			Y: encoding.EncodeToString(pub.Y.FillBytes(make([]byte, 32))),
+		}
+	}
+	panic("unsupported test key")
+}
+
+// sign builds a token with the given header algorithm, which tests may set
+// to something other than the key's own
+func (s testSigner) sign(t *testing.T, alg string, claims map[string]interface{}) string {
+	t.Helper()
+	head, _ := json.Marshal(map[string]string{"alg": alg, "kid": s.kid, "typ": "JWT"})
+	body, _ := json.Marshal(claims)
+	signingInput := encoding.EncodeToString(head) + "." + encoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signingInput))
+
+	var sig []byte
+	switch key := s.key.(type) {
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatalf("signing: %v", err)
+		}
+	case *ecdsa.PrivateKey:
+		r, ss, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatalf("signing: %v", err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), ss.FillBytes(make([]byte, 32))...)
+	}
+	return signingInput + "." + encoding.EncodeToString(sig)
+}
+
+// writeJWKS publishes the signers' public keys at path
+func writeJWKS(t *testing.T, path string, signers ...testSigner) {
+	t.Helper()
+	doc := struct {
+		Keys []jwk `json:"keys"`
+	}{}
+	for _, s := range signers {
+		doc.Keys = append(doc.Keys, s.jwk())
+	}
+	raw, _ := json.Marshal(doc)
+	if err := os.WriteFile(path, raw, 0o600); err != nil {
+		t.Fatalf("writing JWKS: %v", err)
+	}
+}
+
+func validClaims(now time.Time) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":    testIssuer,
+		"aud":    testAudience,
+		"sub":    "alice",
+		"iat":    now.Unix(),
+		"exp":    now.Add(time.Hour).Unix(),
+		"tenant": "acme",
+	}
+}
+
+func TestOIDCVerify(t *testing.T) {
+	rsaKey := newRSASigner(t, "rsa-1")
+	ecKey := newECSigner(t, "ec-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaKey, ecKey)
+
+	keys, err := NewKeySet(context.Background(), path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	verifier := NewOIDCVerifier(keys, testIssuer, testAudience, "tenant")
+	now := time.Now()
+
+	with := func(key string, value interface{}) map[string]interface{} {
+		claims := validClaims(now)
+		claims[key] = value
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid RS256", rsaKey.sign(t, "RS256", validClaims(now)), nil},
+		{"valid ES256", ecKey.sign(t, "ES256", validClaims(now)), nil},
+		{"audience list", rsaKey.sign(t, "RS256", with("aud", []string{"other", testAudience})), nil},
+		{"wrong issuer", rsaKey.sign(t, "RS256", with("iss", "https://evil.example.com")), ErrInvalidToken},
+		{"wrong audience", ecKey.sign(t, "ES256", with("aud", "other")), ErrInvalidToken},
+		{"expired", rsaKey.sign(t, "RS256", with("exp", now.Add(-time.Hour).Unix())), ErrTokenExpired},
+		{"not yet valid", rsaKey.sign(t, "RS256", with("nbf", now.Add(time.Hour).Unix())), ErrInvalidToken},
+		{"RSA key with ES256 header", rsaKey.sign(t, "ES256", validClaims(now)), ErrInvalidToken},
+		{"EC key with RS256 header", ecKey.sign(t, "RS256", validClaims(now)), ErrInvalidToken},
+		{"non-string tenant", rsaKey.sign(t, "RS256", with("tenant", 7)), ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		claims, err := verifier.Verify(context.Background(), tt.token, now)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: err = %v, want %v", tt.name, err, tt.want)
+			continue
+		}
+		if err == nil && (claims.Subject != "alice" || claims.Tenant != "acme") {
+			t.Errorf("%s: claims = %+v", tt.name, claims)
+		}
+	}
+}
+
+func TestOIDCRejectsUnsignedTokens(t *testing.T) {
+	rsaKey := newRSASigner(t, "rsa-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, rsaKey)
+
+	keys, err := NewKeySet(context.Background(), path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	verifier := NewOIDCVerifier(keys, testIssuer, testAudience, "")
+	now := time.Now()
+
+	signed := rsaKey.sign(t, "RS256", validClaims(now))
+	head, _ := json.Marshal(map[string]string{"alg": "none", "kid": rsaKey.kid})
+	body, _ := json.Marshal(validClaims(now))
+	unsigned := encoding.EncodeToString(head) + "." + encoding.EncodeToString(body) + "."
+
+	for name, token := range map[string]string{
+		"alg none":          unsigned,
+		"truncated":         signed[:len(signed)-8],
+		"unknown key":       newRSASigner(t, "rsa-2").sign(t, "RS256", validClaims(now)),
+		"two segments":      encoding.EncodeToString(head) + "." + encoding.EncodeToString(body),
+		"garbage signature": signed + "x",
+	} {
+		if _, err := verifier.Verify(context.Background(), token, now); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestKeySetRefetchesUnknownKeys(t *testing.T) {
+	first := newECSigner(t, "ec-1")
+	rotated := newECSigner(t, "ec-2")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, first)
+
+	keys, err := NewKeySet(context.Background(), path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	if _, err := keys.Key(context.Background(), "ec-1"); err != nil {
+		t.Fatalf("Key(ec-1): %v", err)
+	}
+
+	// The provider rotates in a new key right after we loaded the set
+	writeJWKS(t, path, first, rotated)
+	loadedAt := keys.fetchedAt
+
+	// Within minRefetch an unknown key ID must not reload the set
+	if _, err := keys.Key(context.Background(), "ec-2"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Key(ec-2) within minRefetch = %v, want ErrUnknownKey", err)
+	}
+	if !keys.fetchedAt.Equal(loadedAt) {
+		t.Fatal("an unknown key ID reloaded the set within minRefetch")
+	}
+
+	// Once minRefetch has passed the unknown key ID triggers a reload
+	keys.fetchedAt = time.Now().Add(-minRefetch - time.Second)
+	if _, err := keys.Key(context.Background(), "ec-2"); err != nil {
+		t.Fatalf("Key(ec-2) after minRefetch: %v", err)
+	}
+
+	// Made-up key IDs are throttled by the reload they just caused
+	writeJWKS(t, path, rotated)
+	if _, err := keys.Key(context.Background(), "made-up"); !errors.Is(err, ErrUnknownKey) {
+		t.Fatalf("Key(made-up) = %v, want ErrUnknownKey", err)
+	}
+	if _, err := keys.Key(context.Background(), "ec-1"); err != nil {
+		t.Errorf("Key(ec-1) was dropped without a reload: %v", err)
+	}
+}
+
+func TestKeySetKeepsKeysWhenReloadFails(t *testing.T) {
+	signer := newRSASigner(t, "rsa-1")
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	writeJWKS(t, path, signer)
+
+	keys, err := NewKeySet(context.Background(), path, time.Hour)
+	if err != nil {
+		t.Fatalf("NewKeySet: %v", err)
+	}
+	if err := os.WriteFile(path, []byte("not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	keys.fetchedAt = time.Now().Add(-2 * time.Hour)
+
+	if _, err := keys.Key(context.Background(), "rsa-1"); err != nil {
+		t.Errorf("Key(rsa-1) after a failed reload: %v", err)
+	}
+}
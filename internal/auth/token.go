@@ -0,0 +1,167 @@
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+)
+
+// Where middleware stores who is calling on the echo context
+const (
+	UserContextKey      = "auth_user"       // End user ID, for bearer tokens
+	TenantContextKey    = "auth_tenant"     // Tenant the request acts within
+	APIKeyContextKey    = "auth_api_key"    // API key ID, for service callers
+	KeyTenantContextKey = "auth_key_tenant" // Tenant the API key belongs to, empty for platform keys
+	ScopesContextKey    = "auth_scopes"     // Scopes granted to the API key or end user
+)
+
+// clockSkew is how far token timestamps may disagree with our clock
+const clockSkew = 30 * time.Second
+
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrTokenExpired = errors.New("token expired")
+)
+
+// Claims are the JWT claims we issue and check
+type Claims struct {
+	Subject   string        `json:"sub"`
+	Tenant    string        `json:"tenant,omitempty"`
+	Scopes    models.Scopes `json:"scopes,omitempty"`
+	IssuedAt  int64         `json:"iat"`
+	NotBefore int64         `json:"nbf,omitempty"`
+	ExpiresAt int64         `json:"exp"`
+}
+
+type header struct {
+	Alg string `json:"alg"`
+	Typ string `json:"typ,omitempty"`
+}
+
+var encoding = base64.RawURLEncoding
+
+// Sign encodes claims as an HS256 JWT
+func Sign(secret []byte, claims Claims) (string, error) {
+	head, err := json.Marshal(header{Alg: "HS256", Typ: "JWT"})
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := encoding.EncodeToString(head) + "." + encoding.EncodeToString(body)
+	return signingInput + "." + encoding.EncodeToString(signature(secret, signingInput)), nil
+}
+
+// Verify checks an HS256 JWT's signature and lifetime and returns its claims
+func Verify(secret []byte, token string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+
+	var head header
+	if err := decodeSegment(parts[0], &head); err != nil || head.Alg != "HS256" {
+		return nil, ErrInvalidToken
+	}
+	sig, err := encoding.DecodeString(parts[2])
+	if err != nil || !hmac.Equal(sig, signature(secret, parts[0]+"."+parts[1])) {
+		return nil, ErrInvalidToken
+	}
+
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil || claims.Subject == "" || claims.ExpiresAt == 0 {
+		return nil, ErrInvalidToken
+	}
+	if now.Add(-clockSkew).Unix() >= claims.ExpiresAt {
+		return nil, ErrTokenExpired
+	}
+	if claims.NotBefore != 0 && now.Add(clockSkew).Unix() < claims.NotBefore {
+		return nil, ErrInvalidToken
+	}
+	return &claims, nil
+}
+
+// Identity is who a verified token was issued to and what it allows
+type Identity struct {
+	Tenant string
+	User   string
+	Scopes models.Scopes
+}
+
+// Issue mints a token for a tenant's user signed with WS_TOKEN_SECRET, valid
+// for WS_TOKEN_TTL. The token only carries the end-user part of scopes.
+func Issue(tenant, userID string, scopes models.Scopes) (string, time.Time, error) {
+	now := time.Now()
+	expires := now.Add(config.AppConfig.WSTokenTTL)
+	token, err := Sign([]byte(config.AppConfig.WSTokenSecret), Claims{
+		Subject:   userID,
+		Tenant:    tenant,
+		Scopes:    scopes.Delegated(),
+		IssuedAt:  now.Unix(),
+		ExpiresAt: expires.Unix(),
+	})
+	return token, expires, err
+}
+
+// Authenticate verifies either a token we signed with WS_TOKEN_SECRET or one
+// from the configured identity provider, and returns who it identifies.
+// Tokens that don't name a tenant belong to the default tenant. Our tokens
+// allow only the scopes they were minted with, identity provider users act
+// for themselves with every end-user scope.
+func Authenticate(ctx context.Context, token string) (*Identity, error) {
+	var head header
+	if err := decodeSegment(strings.SplitN(token, ".", 2)[0], &head); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	var identity Identity
+	if head.Alg == "HS256" {
+		claims, err := Verify([]byte(config.AppConfig.WSTokenSecret), token, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		identity = Identity{Tenant: claims.Tenant, User: claims.Subject, Scopes: claims.Scopes.Delegated()}
+	} else {
+		if oidc == nil {
+			return nil, ErrInvalidToken
+		}
+		claims, err := oidc.Verify(ctx, token, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		identity = Identity{Tenant: claims.Tenant, User: claims.Subject, Scopes: models.UserScopes}
+	}
+
+	if identity.Tenant == "" {
+		identity.Tenant = models.DefaultTenant
+	}
+	if !models.ValidTenantID(identity.Tenant) {
+		return nil, ErrInvalidToken
+	}
+	return &identity, nil
+}
+
+func signature(secret []byte, signingInput string) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return mac.Sum(nil)
+}
+
+func decodeSegment(segment string, v any) error {
+	raw, err := encoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
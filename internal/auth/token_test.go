@@ -0,0 +1,61 @@
+package auth
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestMain sets the configuration tokens are signed with, which tests don't load
+func TestMain(m *testing.M) {
+	config.AppConfig.WSTokenSecret = "test-secret-test-secret-test-secret"
+	config.AppConfig.WSTokenTTL = time.Hour
+	os.Exit(m.Run())
+}
+
+func TestIssuedTokensOnlyCarryEndUserScopes(t *testing.T) {
+	tests := []struct {
+		name string
+		key  models.Scopes
+		want models.Scopes
+	}{
+		{"send key", models.Scopes{models.ScopeSend, models.ScopeIssueTokens}, models.Scopes{models.ScopeSend}},
+		{"history key", models.Scopes{models.ScopeReadHistory}, models.Scopes{models.ScopeReadHistory}},
+		{"admin key", models.Scopes{models.ScopeAdmin}, models.UserScopes},
+		{"no scopes", nil, models.Scopes{}},
+	}
+	for _, tt := range tests {
+		token, _, err := Issue("acme", "alice", tt.key)
+		if err != nil {
+			t.Fatalf("%s: Issue: %v", tt.name, err)
+		}
+		identity, err := Authenticate(context.Background(), token)
+		if err != nil {
+			t.Fatalf("%s: Authenticate: %v", tt.name, err)
+		}
+		if identity.Tenant != "acme" || identity.User != "alice" {
+			t.Errorf("%s: identity = %+v", tt.name, identity)
+		}
+		if !equalScopes(identity.Scopes, tt.want) {
+			t.Errorf("%s: scopes = %v, want %v", tt.name, identity.Scopes, tt.want)
+		}
+		if identity.Scopes.Has(models.ScopeIssueTokens) || identity.Scopes.Has(models.ScopeAdmin) {
+			t.Errorf("%s: token can mint tokens or administer", tt.name)
+		}
+	}
+}
+
+func equalScopes(a, b models.Scopes) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
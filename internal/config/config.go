@@ -1,12 +1,16 @@
 package config
 
 import (
+	"errors"
 	"fmt"
-	"log"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
 	"github.com/joho/godotenv"
 )
 
@@ -21,12 +25,39 @@ type ConfigApplication struct {
 	DBUsername          string
 	DBPassword          string
 	DBSSLMode           string
-	CloudinaryCloudName string 
-	CloudinaryAPIKey    string 
-	CloudinaryAPISecret string 
-	RedisAddress        string // Added field for Redis
-	RedisUsername       string // Added field for Redis
-	RedisPassword       string // Added field for Redis
+	CloudinaryCloudName string
+	CloudinaryAPIKey    string
+	CloudinaryAPISecret string
+	BlobStore           string        // local or cloudinary
+	UploadDir           string        // Where the local blob store keeps files
+	MaxUploadSize       int64         // Largest accepted attachment in bytes
+	ThumbnailSize       int           // Longest edge of generated image thumbnails in pixels
+	ImageWorkers        int           // Uploaded images processed at once, each can take up to 64MB
+	RedisAddress        string        // Added field for Redis
+	RedisUsername       string        // Added field for Redis
+	RedisPassword       string        // Added field for Redis
+	HistoryLimit        int           // Number of messages replayed after registration
+	OfflineQueueTTL     time.Duration // How long undelivered messages are kept
+	OfflineQueueMaxLen  int           // Maximum undelivered messages kept per receiver
+	NodeID              string        // Identifies this instance in the cluster
+	NodeAdvertiseURL    string        // Public /ws/server URL of this instance, enables user-to-node routing
+	SendBufferSize      int           // Outbound frames buffered per hub connection
+	SlowConsumerPolicy  string        // drop_oldest, drop_newest or disconnect when a buffer is full
+	WSPingInterval      time.Duration // How often hub connections are pinged
+	WSPongWait          time.Duration // How long to wait for any frame, including pongs, before dropping a connection
+	WSIdleTimeout       time.Duration // Drop connections that send no messages for this long, 0 disables
+	DuplicateSession    string        // reject or takeover when a connected user registers again
+	AdminUsers          []string      // Users allowed to broadcast over the websocket
+	TypingTimeout       time.Duration // Typing indicators expire if no typing_stop arrives in time
+	IdempotencyWindow   time.Duration // How long retried sends with the same idempotency key are deduplicated
+	WSTokenSecret       string        // HMAC secret websocket tokens are signed with
+	WSTokenTTL          time.Duration // Lifetime of websocket tokens minted by this server
+	WSTicketTTL         time.Duration // How long a single-use connection ticket can be redeemed
+	OIDCJWKS            string        // JWKS file path or URL of the identity provider, enables end-user bearer tokens
+	OIDCIssuer          string        // Required iss claim of identity provider tokens
+	OIDCAudience        string        // Required aud claim of identity provider tokens
+	OIDCJWKSRefresh     time.Duration // How often the JWKS is reloaded to pick up rotated keys
+	OIDCTenantClaim     string        // Claim naming the user's tenant in identity provider tokens
 }
 
 var AppConfig ConfigApplication
@@ -51,94 +82,238 @@ func findRootDir() (string, error) {
 	}
 }
 
-// init function is used to initialize the application configuration.
-// It loads environment variables from .env files located at different paths.
-// The function iterates over the paths and loads the first .env file it finds.
-// If it fails to load an .env file from any of the paths, it logs a fatal error.
-// After successfully loading the .env file, it assigns the environment variables to the AppConfig struct.
-func init() {
+// lookupInt reads an optional integer environment variable, falling back to def
+func lookupInt(key string, def int) (int, error) {
+	value, present := os.LookupEnv(key)
+	if !present || value == "" {
+		return def, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s environment variable must be an integer: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// lookupDuration reads an optional duration environment variable (e.g. "30s"), falling back to def
+func lookupDuration(key string, def time.Duration) (time.Duration, error) {
+	value, present := os.LookupEnv(key)
+	if !present || value == "" {
+		return def, nil
+	}
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("%s environment variable must be a duration: %w", key, err)
+	}
+	return parsed, nil
+}
+
+// Load initializes the application configuration. It loads the .env file
+// at the project root, then assigns the environment variables to the
+// AppConfig struct. It returns an error if the file can't be loaded or a
+// variable is missing or invalid.
+func Load() error {
 	// Find project root and load .env
 	rootDir, err := findRootDir()
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
 
 	var (
 		present bool
 	)
-	err = godotenv.Load(filepath.Join(rootDir, ".env"))
-	if err != nil {
-		log.Fatal(err)
+	if err := godotenv.Load(filepath.Join(rootDir, ".env")); err != nil {
+		return err
 	}
 
 	AppConfig.Env, present = os.LookupEnv("GO_ENV")
 	if !present {
-		panic("GO_ENV environment variable is not set")
+		return errors.New("GO_ENV environment variable is not set")
 	}
 
 	AppConfig.PORT, present = os.LookupEnv("GO_PORT")
 	if !present {
-		panic("GO_PORT environment variable is not set")
+		return errors.New("GO_PORT environment variable is not set")
 	}
 
 	AppConfig.APIKey, present = os.LookupEnv("API_KEY")
 	if !present {
-		panic("API_KEY environment variable is not set")
+		return errors.New("API_KEY environment variable is not set")
 	}
 
 	AppConfig.DBName, present = os.LookupEnv("DB_NAME")
 	if !present {
-		panic("DB_NAME environment variable is not set")
+		return errors.New("DB_NAME environment variable is not set")
 	}
 
 	AppConfig.DBHost, present = os.LookupEnv("DB_HOST")
 	if !present {
-		panic("DB_HOST environment variable is not set")
+		return errors.New("DB_HOST environment variable is not set")
 	}
 
 	AppConfig.DBUsername, present = os.LookupEnv("DB_USERNAME")
 	if !present {
-		panic("DB_USERNAME environment variable is not set")
+		return errors.New("DB_USERNAME environment variable is not set")
 	}
 
 	AppConfig.DBPassword, present = os.LookupEnv("DB_PASSWORD")
 	if !present {
-		panic("DB_PASSWORD environment variable is not set")
+		return errors.New("DB_PASSWORD environment variable is not set")
 	}
 
 	AppConfig.DBSSLMode, present = os.LookupEnv("DB_SSLMODE")
 	if !present {
-		panic("DB_SSLMODE environment variable is not set")
+		return errors.New("DB_SSLMODE environment variable is not set")
+	}
+
+	// Cloudinary is only needed when it backs attachment storage
+	AppConfig.BlobStore = os.Getenv("BLOB_STORE")
+	switch AppConfig.BlobStore {
+	case "":
+		AppConfig.BlobStore = "local"
+	case "local", "cloudinary":
+	default:
+		return errors.New("BLOB_STORE must be one of local, cloudinary")
 	}
+	cloudinaryRequired := AppConfig.BlobStore == "cloudinary"
 
 	AppConfig.CloudinaryCloudName, present = os.LookupEnv("CLOUDINARY_CLOUD_NAME") // Added for Cloudinary
-	if !present {
-		panic("CLOUDINARY_CLOUD_NAME environment variable is not set")
+	if !present && cloudinaryRequired {
+		return errors.New("CLOUDINARY_CLOUD_NAME environment variable is not set")
 	}
 
 	AppConfig.CloudinaryAPIKey, present = os.LookupEnv("CLOUDINARY_API_KEY") // Added for Cloudinary
-	if !present {
-		panic("CLOUDINARY_API_KEY environment variable is not set")
+	if !present && cloudinaryRequired {
+		return errors.New("CLOUDINARY_API_KEY environment variable is not set")
 	}
 
 	AppConfig.CloudinaryAPISecret, present = os.LookupEnv("CLOUDINARY_API_SECRET") // Added for Cloudinary
-	if !present {
-		panic("CLOUDINARY_API_SECRET environment variable is not set")
+	if !present && cloudinaryRequired {
+		return errors.New("CLOUDINARY_API_SECRET environment variable is not set")
+	}
+
+	AppConfig.UploadDir, present = os.LookupEnv("UPLOAD_DIR")
+	if !present || AppConfig.UploadDir == "" {
+		AppConfig.UploadDir = filepath.Join(rootDir, "uploads")
+	}
+	maxUploadSize, err := lookupInt("MAX_UPLOAD_SIZE", 10<<20)
+	if err != nil {
+		return err
+	}
+	AppConfig.MaxUploadSize = int64(maxUploadSize)
+	if AppConfig.ThumbnailSize, err = lookupInt("CHAT_THUMBNAIL_SIZE", 320); err != nil {
+		return err
+	}
+	if AppConfig.ImageWorkers, err = lookupInt("CHAT_IMAGE_WORKERS", 2); err != nil {
+		return err
 	}
 
 	AppConfig.RedisAddress, present = os.LookupEnv("REDIS_ADDRESS") // Added for Redis
 	if !present {
-		panic("REDIS_ADDRESS environment variable is not set")
+		return errors.New("REDIS_ADDRESS environment variable is not set")
 	}
 
 	AppConfig.RedisUsername, present = os.LookupEnv("REDIS_USERNAME") // Added for Redis
 	if !present {
-		panic("REDIS_USERNAME environment variable is not set")
+		return errors.New("REDIS_USERNAME environment variable is not set")
 	}
 
 	AppConfig.RedisPassword, present = os.LookupEnv("REDIS_PASSWORD") // Added for Redis
 	if !present {
-		panic("REDIS_PASSWORD environment variable is not set")
+		return errors.New("REDIS_PASSWORD environment variable is not set")
+	}
+
+	if AppConfig.HistoryLimit, err = lookupInt("CHAT_HISTORY_LIMIT", 50); err != nil {
+		return err
+	}
+	if AppConfig.OfflineQueueTTL, err = lookupDuration("CHAT_OFFLINE_TTL", 7*24*time.Hour); err != nil {
+		return err
+	}
+	if AppConfig.OfflineQueueMaxLen, err = lookupInt("CHAT_OFFLINE_MAX_LEN", 500); err != nil {
+		return err
 	}
 
+	AppConfig.NodeID, present = os.LookupEnv("NODE_ID")
+	if !present || AppConfig.NodeID == "" {
+		hostname, _ := os.Hostname()
+		AppConfig.NodeID = fmt.Sprintf("%s-%d", hostname, os.Getpid())
+	}
+	AppConfig.NodeAdvertiseURL = os.Getenv("NODE_ADVERTISE_URL")
+
+	if AppConfig.SendBufferSize, err = lookupInt("CHAT_SEND_BUFFER", 256); err != nil {
+		return err
+	}
+	if AppConfig.SendBufferSize <= 0 {
+		return errors.New("CHAT_SEND_BUFFER environment variable must be positive")
+	}
+	AppConfig.SlowConsumerPolicy = os.Getenv("CHAT_SLOW_CONSUMER_POLICY")
+	switch AppConfig.SlowConsumerPolicy {
+	case "":
+		AppConfig.SlowConsumerPolicy = "drop_oldest"
+	case "drop_oldest", "drop_newest", "disconnect":
+	default:
+		return errors.New("CHAT_SLOW_CONSUMER_POLICY must be one of drop_oldest, drop_newest, disconnect")
+	}
+
+	if AppConfig.WSPingInterval, err = lookupDuration("WS_PING_INTERVAL", 30*time.Second); err != nil {
+		return err
+	}
+	if AppConfig.WSPongWait, err = lookupDuration("WS_PONG_WAIT", 60*time.Second); err != nil {
+		return err
+	}
+	if AppConfig.WSIdleTimeout, err = lookupDuration("WS_IDLE_TIMEOUT", 0); err != nil {
+		return err
+	}
+	if AppConfig.WSPingInterval <= 0 || AppConfig.WSPingInterval >= AppConfig.WSPongWait {
+		return errors.New("WS_PING_INTERVAL must be positive and shorter than WS_PONG_WAIT")
+	}
+
+	AppConfig.DuplicateSession = os.Getenv("CHAT_DUPLICATE_SESSION")
+	switch AppConfig.DuplicateSession {
+	case "":
+		AppConfig.DuplicateSession = "reject"
+	case "reject", "takeover":
+	default:
+		return errors.New("CHAT_DUPLICATE_SESSION must be one of reject, takeover")
+	}
+
+	for _, user := range strings.Split(os.Getenv("CHAT_ADMIN_USERS"), ",") {
+		if user = strings.TrimSpace(user); user != "" {
+			AppConfig.AdminUsers = append(AppConfig.AdminUsers, user)
+		}
+	}
+
+	if AppConfig.TypingTimeout, err = lookupDuration("CHAT_TYPING_TIMEOUT", 10*time.Second); err != nil {
+		return err
+	}
+	if AppConfig.IdempotencyWindow, err = lookupDuration("CHAT_IDEMPOTENCY_WINDOW", 10*time.Minute); err != nil {
+		return err
+	}
+
+	AppConfig.WSTokenSecret, present = os.LookupEnv("WS_TOKEN_SECRET")
+	if !present || len(AppConfig.WSTokenSecret) < 32 {
+		return errors.New("WS_TOKEN_SECRET environment variable must be set to at least 32 characters")
+	}
+	if AppConfig.WSTokenTTL, err = lookupDuration("WS_TOKEN_TTL", time.Hour); err != nil {
+		return err
+	}
+	if AppConfig.WSTicketTTL, err = lookupDuration("WS_TICKET_TTL", 30*time.Second); err != nil {
+		return err
+	}
+
+	// Identity provider tokens are only accepted when a JWKS is configured
+	AppConfig.OIDCJWKS = os.Getenv("OIDC_JWKS")
+	AppConfig.OIDCIssuer = os.Getenv("OIDC_ISSUER")
+	AppConfig.OIDCAudience = os.Getenv("OIDC_AUDIENCE")
+	if AppConfig.OIDCJWKS != "" && (AppConfig.OIDCIssuer == "" || AppConfig.OIDCAudience == "") {
+		return errors.New("OIDC_ISSUER and OIDC_AUDIENCE must be set when OIDC_JWKS is")
+	}
+	if AppConfig.OIDCJWKSRefresh, err = lookupDuration("OIDC_JWKS_REFRESH", time.Hour); err != nil {
+		return err
+	}
+	AppConfig.OIDCTenantClaim = os.Getenv("OIDC_TENANT_CLAIM")
+	if AppConfig.OIDCTenantClaim == "" {
+		AppConfig.OIDCTenantClaim = "tenant"
+	}
+	return nil
 }
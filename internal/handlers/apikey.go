@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"chatsystem/internal/auth"
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+type APIKeyHandler struct {
+	apiKeyService *services.APIKeyService
+}
+
+func NewAPIKeyHandler(apiKeyService *services.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		apiKeyService: apiKeyService,
+	}
+}
+
+// CreateHandler issues a new API key. The key is only ever returned here.
+// Tenant keys can only create keys for their own tenant, platform keys may
+// name any tenant, or none to create another platform key.
+func (h *APIKeyHandler) CreateHandler(c echo.Context) error {
+	type Request struct {
+		TenantID  string     `json:"tenant_id"`
+		Name      string     `json:"name" validate:"required"`
+		Scopes    []string   `json:"scopes" validate:"required"`
+		ExpiresAt *time.Time `json:"expires_at"`
+	}
+
+	req := new(Request)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+	if req.ExpiresAt != nil && !req.ExpiresAt.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "expires_at must be in the future")
+	}
+
+	keyTenant, _ := c.Get(auth.KeyTenantContextKey).(string)
+	switch {
+	case keyTenant != "" && req.TenantID != "" && req.TenantID != keyTenant:
+		return echo.NewHTTPError(http.StatusForbidden, "Not allowed to create keys for another tenant")
+	case keyTenant != "":
+		req.TenantID = keyTenant
+	case req.TenantID != "" && !models.ValidTenantID(req.TenantID):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant_id")
+	}
+
+	createdBy, _ := c.Get(auth.APIKeyContextKey).(string)
+	key, secret, err := h.apiKeyService.CreateKey(req.TenantID, req.Name, req.Scopes, req.ExpiresAt, createdBy)
+	if errors.Is(err, services.ErrInvalidScope) {
+		return echo.NewHTTPError(http.StatusBadRequest, "scopes must be one or more of send, read-history, issue-tokens, admin")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to create API key: %v", err))
+	}
+
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"key":     secret,
+		"api_key": key,
+	})
+}
+
+// ListHandler returns API keys without their secrets. Tenant keys only see
+// their own tenant's keys.
+func (h *APIKeyHandler) ListHandler(c echo.Context) error {
+	var (
+		keys []models.APIKey
+		err  error
+	)
+	if keyTenant, _ := c.Get(auth.KeyTenantContextKey).(string); keyTenant != "" {
+		keys, err = h.apiKeyService.ListTenantKeys(keyTenant)
+	} else {
+		keys, err = h.apiKeyService.ListKeys()
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to list API keys: %v", err))
+	}
+	return c.JSON(http.StatusOK, keys)
+}
+
+// RevokeHandler stops an API key from being accepted. Tenant keys can only
+// revoke their own tenant's keys.
+func (h *APIKeyHandler) RevokeHandler(c echo.Context) error {
+	key, err := h.apiKeyService.GetKey(c.Param("keyID"))
+	if err == nil && !isPlatformKey(c) {
+		if keyTenant, _ := c.Get(auth.KeyTenantContextKey).(string); key.TenantID != keyTenant {
+			// Don't reveal that another tenant's key exists
+			err = services.ErrAPIKeyNotFound
+		}
+	}
+	if err == nil {
+		key, err = h.apiKeyService.RevokeKey(key.ID)
+	}
+	if errors.Is(err, services.ErrAPIKeyNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "API key not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to revoke API key: %v", err))
+	}
+	return c.JSON(http.StatusOK, key)
+}
+
+// isPlatformKey reports whether the request uses an API key that isn't bound
+// to a tenant
+func isPlatformKey(c echo.Context) bool {
+	_, isKey := c.Get(auth.APIKeyContextKey).(string)
+	keyTenant, _ := c.Get(auth.KeyTenantContextKey).(string)
+	return isKey && keyTenant == ""
+}
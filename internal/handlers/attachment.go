@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"chatsystem/internal/auth"
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"errors"
+	"fmt"
+	"io/fs"
+	"mime"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AttachmentHandler struct {
+	attachmentService *services.AttachmentService
+	tenantService     *services.TenantService
+}
+
+func NewAttachmentHandler(attachmentService *services.AttachmentService, tenantService *services.TenantService) *AttachmentHandler {
+	return &AttachmentHandler{
+		attachmentService: attachmentService,
+		tenantService:     tenantService,
+	}
+}
+
+// UploadHandler accepts a multipart upload with a "file" part and the
+// uploading user's "user_id". The returned attachment ID can be referenced
+// from that user's chat messages. Tenants may set their own size limit.
+func (h *AttachmentHandler) UploadHandler(c echo.Context) error {
+	tenant := tenantOf(c)
+	settings, err := h.tenantService.GetTenant(tenant)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to load tenant: %v", err))
+	}
+	maxSize := config.AppConfig.MaxUploadSize
+	if settings.MaxUploadSize > 0 {
+		maxSize = settings.MaxUploadSize
+	}
+	// Leave headroom for the multipart envelope around the file
+	c.Request().Body = http.MaxBytesReader(c.Response(), c.Request().Body, maxSize+1<<20)
+
+	userID := c.FormValue("user_id")
+	if userID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "user_id is required")
+	}
+	if err := checkUser(c, userID, models.ScopeSend); err != nil {
+		return err
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "file is required")
+	}
+	if header.Size > maxSize {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("file exceeds the %d byte limit", maxSize))
+	}
+
+	file, err := header.Open()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid file")
+	}
+	defer file.Close()
+
+	attachment, err := h.attachmentService.Upload(c.Request().Context(), tenant, userID, header.Filename, header.Size, file)
+	if errors.Is(err, services.ErrInvalidImage) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if errors.Is(err, services.ErrUnsupportedType) {
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType,
+			"Only images, PDF, plain text and zip or Office documents can be attached")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to store file: %v", err))
+	}
+
+	return c.JSON(http.StatusCreated, attachment.ToRef())
+}
+
+// DownloadHandler serves an attachment to callers of its tenant. End users
+// only get attachments they uploaded or that were sent to them.
+func (h *AttachmentHandler) DownloadHandler(c echo.Context) error {
+	return h.serve(c, false)
+}
+
+// ThumbnailHandler serves an image attachment's thumbnail, with the same
+// access rules as DownloadHandler
+func (h *AttachmentHandler) ThumbnailHandler(c echo.Context) error {
+	return h.serve(c, true)
+}
+
+func (h *AttachmentHandler) serve(c echo.Context, thumbnail bool) error {
+	attachment, err := h.attachmentService.GetAttachment(tenantOf(c), c.Param("attachmentID"))
+	if errors.Is(err, services.ErrAttachmentNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Attachment not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to load attachment: %v", err))
+	}
+
+	if userID, isUser := c.Get(auth.UserContextKey).(string); isUser {
+		allowed, err := h.attachmentService.CanView(attachment, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Failed to check access: %v", err))
+		}
+		if !allowed {
+			return echo.NewHTTPError(http.StatusNotFound, "Attachment not found")
+		}
+	}
+
+	file, err := h.attachmentService.Open(c.Request().Context(), attachment, thumbnail)
+	if errors.Is(err, services.ErrPublicBlob) {
+		if thumbnail {
+			return c.Redirect(http.StatusFound, attachment.ThumbURL)
+		}
+		return c.Redirect(http.StatusFound, attachment.URL)
+	}
+	if errors.Is(err, services.ErrAttachmentNotFound) || errors.Is(err, fs.ErrNotExist) {
+		return echo.NewHTTPError(http.StatusNotFound, "Attachment not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to open attachment: %v", err))
+	}
+	defer file.Close()
+
+	contentType := attachment.ContentType
+	if thumbnail {
+		contentType = services.ThumbnailType(attachment)
+	}
+	// Never let a browser render uploads as part of our origin
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Name}))
+	header.Set(echo.HeaderXContentTypeOptions, "nosniff")
+	header.Set("Cache-Control", "private")
+	http.ServeContent(c.Response(), c.Request(), "", attachment.CreatedAt, file)
+	return nil
+}
@@ -1,12 +1,16 @@
 package handlers
 
 import (
+	"chatsystem/internal/auth"
+	"chatsystem/internal/models"
 	"chatsystem/internal/services"
 	ws "chatsystem/internal/websocket"
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gorilla/websocket"
@@ -40,38 +44,44 @@ func (h WebSocketChatHandler) RegisterHandler(c echo.Context) error {
 	if req.UserID == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "user_id is required")
 	}
+	if err := checkUser(c, req.UserID, models.ScopeSend); err != nil {
+		return err
+	}
 
 	// Get or create client
-	client := h.clientManager.GetOrCreateClient(req.UserID)
+	tenant := tenantOf(c)
+	client := h.clientManager.GetOrCreateClient(tenant, req.UserID)
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	// Connect to WebSocket server
 	if err := client.ChatConnect(ctx); err != nil {
-		h.clientManager.RemoveClient(req.UserID)
+		h.clientManager.RemoveClient(tenant, req.UserID)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Failed to connect: %v", err))
 	}
 
 	// Register with server
 	if err := client.ChatRegister(); err != nil {
-		h.clientManager.RemoveClient(req.UserID)
+		h.clientManager.RemoveClient(tenant, req.UserID)
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Registration failed: %v", err))
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"status":  "registered",
+		"tenant":  tenant,
 		"user_id": req.UserID,
 	})
 }
 
 func (h WebSocketChatHandler) SendHandler(c echo.Context) error {
 	type Request struct {
-		UserID   string `json:"user_id" validate:"required"`
-		Receiver string `json:"receiver" validate:"required"`
-		Text     string `json:"text" validate:"required"`
+		UserID         string `json:"user_id" validate:"required"`
+		Receiver       string `json:"receiver" validate:"required"`
+		Text           string `json:"text" validate:"required"`
+		IdempotencyKey string `json:"idempotency_key"`
 	}
 
 	req := new(Request)
@@ -82,20 +92,28 @@ func (h WebSocketChatHandler) SendHandler(c echo.Context) error {
 	if req.UserID == "" || req.Receiver == "" || req.Text == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "user_id, receiver, and text are required")
 	}
+	if err := checkUser(c, req.UserID, models.ScopeSend); err != nil {
+		return err
+	}
 
-	client, exists := h.clientManager.GetClient(req.UserID)
+	client, exists := h.clientManager.GetClient(tenantOf(c), req.UserID)
 	if !exists {
 		return echo.NewHTTPError(http.StatusNotFound, "User not registered")
 	}
 
-	if err := client.SendMessage(req.Receiver, req.Text); err != nil {
+	// Reuse the caller's key so retried requests are deduplicated server-side
+	if req.IdempotencyKey == "" {
+		req.IdempotencyKey = services.NewID()
+	}
+	if err := client.SendChatMessageWithKey(req.Receiver, req.Text, req.IdempotencyKey); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError,
 			fmt.Sprintf("Failed to send message: %v", err))
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"status":    "sent",
-		"timestamp": time.Now(),
+		"status":          "sent",
+		"idempotency_key": req.IdempotencyKey,
+		"timestamp":       time.Now(),
 	})
 }
 
@@ -104,8 +122,11 @@ func (h WebSocketChatHandler) ListenHandler(c echo.Context) error {
 	if userID == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "userID parameter is required")
 	}
+	if err := checkUser(c, userID, models.ScopeSend); err != nil {
+		return err
+	}
 
-	client, exists := h.clientManager.GetClient(userID)
+	client, exists := h.clientManager.GetClient(tenantOf(c), userID)
 	if !exists {
 		return echo.NewHTTPError(http.StatusNotFound, "User not registered")
 	}
@@ -164,11 +185,141 @@ func (h WebSocketChatHandler) DisconnectHandler(c echo.Context) error {
 	if userID == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "userID parameter is required")
 	}
+	if err := checkUser(c, userID, models.ScopeSend); err != nil {
+		return err
+	}
 
-	h.clientManager.RemoveClient(userID)
+	h.clientManager.RemoveClient(tenantOf(c), userID)
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"status":  "disconnected",
 		"user_id": userID,
 	})
 }
+
+// TokenHandler mints a token for a user of the caller's tenant. API keys
+// need the issue-tokens scope to mint for anyone, end users may only renew
+// their own. The token carries no more than the caller's end-user scopes.
+func (h WebSocketChatHandler) TokenHandler(c echo.Context) error {
+	type Request struct {
+		UserID string `json:"user_id" validate:"required"`
+	}
+
+	req := new(Request)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.UserID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "user_id is required")
+	}
+	if err := checkUser(c, req.UserID, models.ScopeIssueTokens); err != nil {
+		return err
+	}
+
+	token, expires, err := auth.Issue(tenantOf(c), req.UserID, callerScopes(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to issue token: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"token":      token,
+		"expires_at": expires,
+	})
+}
+
+func (h WebSocketChatHandler) ThreadHandler(c echo.Context) error {
+	messageID := c.Param("messageID")
+	if messageID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "messageID parameter is required")
+	}
+
+	limit, err := queryInt(c, "limit", 50)
+	if err != nil || limit <= 0 || limit > 200 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limit must be between 1 and 200")
+	}
+	offset, err := queryInt(c, "offset", 0)
+	if err != nil || offset < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "offset must be a non-negative integer")
+	}
+
+	chat := h.chatService.ForTenant(tenantOf(c))
+	parent, err := chat.GetMessage(messageID)
+	if errors.Is(err, services.ErrMessageNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Message not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to load message: %v", err))
+	}
+
+	// End users can only read threads in their own conversations
+	if userID, isUser := c.Get(auth.UserContextKey).(string); isUser {
+		participant, err := chat.IsParticipant(parent, userID)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError,
+				fmt.Sprintf("Failed to check access: %v", err))
+		}
+		if !participant {
+			return echo.NewHTTPError(http.StatusNotFound, "Message not found")
+		}
+	}
+
+	records, total, err := chat.GetThread(messageID, limit, offset)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to load thread: %v", err))
+	}
+
+	replies := make([]models.Message, 0, len(records))
+	for _, record := range records {
+		replies = append(replies, record.ToMessage())
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"parent":  parent.ToMessage(),
+		"replies": replies,
+		"total":   total,
+		"limit":   limit,
+		"offset":  offset,
+	})
+}
+
+// checkUser keeps end users to their own user ID. API keys may act for any
+// user of their tenant, but only as far as they hold scope.
+func checkUser(c echo.Context, userID, scope string) error {
+	if user, isUser := c.Get(auth.UserContextKey).(string); isUser {
+		if user != userID {
+			return echo.NewHTTPError(http.StatusForbidden, "Not allowed to act for another user")
+		}
+		return nil
+	}
+
+	if !callerScopes(c).Has(scope) {
+		return echo.NewHTTPError(http.StatusForbidden, "API key lacks the "+scope+" scope")
+	}
+	return nil
+}
+
+// callerScopes returns the scopes granted to the API key or end user calling
+func callerScopes(c echo.Context) models.Scopes {
+	scopes, _ := c.Get(auth.ScopesContextKey).(models.Scopes)
+	return scopes
+}
+
+// tenantOf returns the tenant the authenticated request acts within
+func tenantOf(c echo.Context) string {
+	if tenant, ok := c.Get(auth.TenantContextKey).(string); ok && tenant != "" {
+		return tenant
+	}
+	return models.DefaultTenant
+}
+
+// queryInt reads an optional integer query parameter
+func queryInt(c echo.Context, name string, def int) (int, error) {
+	value := c.QueryParam(name)
+	if value == "" {
+		return def, nil
+	}
+	return strconv.Atoi(value)
+}
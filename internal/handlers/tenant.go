@@ -0,0 +1,76 @@
+package handlers
+
+import (
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type TenantHandler struct {
+	tenantService *services.TenantService
+}
+
+func NewTenantHandler(tenantService *services.TenantService) *TenantHandler {
+	return &TenantHandler{
+		tenantService: tenantService,
+	}
+}
+
+// ListHandler returns every configured tenant
+func (h *TenantHandler) ListHandler(c echo.Context) error {
+	tenants, err := h.tenantService.ListTenants()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to list tenants: %v", err))
+	}
+	return c.JSON(http.StatusOK, tenants)
+}
+
+// SaveHandler creates a tenant or replaces its settings and limits. Changes
+// reach every node within the tenant cache lifetime.
+func (h *TenantHandler) SaveHandler(c echo.Context) error {
+	type Request struct {
+		Name              string   `json:"name" validate:"required"`
+		Disabled          bool     `json:"disabled"`
+		MaxConnections    int      `json:"max_connections"`
+		MessagesPerMinute int      `json:"messages_per_minute"`
+		HistoryLimit      int      `json:"history_limit"`
+		MaxUploadSize     int64    `json:"max_upload_size"`
+		AdminUsers        []string `json:"admin_users"`
+	}
+
+	req := new(Request)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Name == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "name is required")
+	}
+	if req.MaxConnections < 0 || req.MessagesPerMinute < 0 || req.HistoryLimit < 0 || req.MaxUploadSize < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "limits can't be negative")
+	}
+
+	tenant, err := h.tenantService.SaveTenant(models.Tenant{
+		ID:                c.Param("tenantID"),
+		Name:              req.Name,
+		Disabled:          req.Disabled,
+		MaxConnections:    req.MaxConnections,
+		MessagesPerMinute: req.MessagesPerMinute,
+		HistoryLimit:      req.HistoryLimit,
+		MaxUploadSize:     req.MaxUploadSize,
+		AdminUsers:        req.AdminUsers,
+	})
+	if errors.Is(err, services.ErrInvalidTenant) {
+		return echo.NewHTTPError(http.StatusBadRequest,
+			"tenant IDs are 1-64 lowercase letters, digits, '-' or '_'")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to save tenant: %v", err))
+	}
+	return c.JSON(http.StatusOK, tenant)
+}
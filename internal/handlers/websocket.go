@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"errors"
+	"fmt"
 	"log"
 	"net/http"
 	"time"
 
+	"chatsystem/internal/auth"
+	"chatsystem/internal/middleware"
 	"chatsystem/internal/models"
 	ws "chatsystem/internal/websocket"
 
@@ -24,6 +28,33 @@ func NewWebSocketHandler(hub *ws.Hub) *WebSocketHandler {
 
 // HandleWebSocket handles WebSocket connections with Echo
 func (h *WebSocketHandler) HandleWebSocket(c echo.Context) error {
+	// The auth middleware has already verified tokens, tickets are redeemed here
+	userID, _ := c.Get(auth.UserContextKey).(string)
+	tenant, _ := c.Get(auth.TenantContextKey).(string)
+	scopes, _ := c.Get(auth.ScopesContextKey).(models.Scopes)
+	ticket := c.QueryParam("ticket")
+	spentTicket := false
+	if userID == "" && ticket != "" {
+		identity, err := h.hub.RedeemTicket(c.Request().Context(), ticket)
+		if errors.Is(err, ws.ErrInvalidTicket) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Invalid or expired ticket")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not redeem ticket")
+		}
+		tenant, userID, scopes = identity.Tenant, identity.User, identity.Scopes
+		spentTicket = true
+	}
+	if userID == "" {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Missing token or ticket")
+	}
+	if !scopes.Has(models.ScopeSend) {
+		return echo.NewHTTPError(http.StatusForbidden, "Token lacks the "+models.ScopeSend+" scope")
+	}
+	if !h.hub.TenantEnabled(tenant) {
+		return echo.NewHTTPError(http.StatusForbidden, "Tenant not found or disabled")
+	}
+
 	// Upgrade HTTP connection to WebSocket
 	upgrader := websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
@@ -37,12 +68,24 @@ func (h *WebSocketHandler) HandleWebSocket(c echo.Context) error {
 		log.Printf("Error upgrading connection: %v", err)
 		return err
 	}
-	defer conn.Close()
+	// All writes go through the client's write pump
+	client := ws.NewClient(conn, tenant, userID, scopes)
+	go client.WritePump()
+	defer client.Close()
+	defer h.hub.UnregisterClient(client)
+
+	// Send users owned by another node there. Browsers can't follow an HTTP
+	// redirect during the handshake, so this happens after the upgrade.
+	userKey := ws.UserKey(tenant, userID)
+	if !h.hub.Router().IsLocal(userKey) {
+		h.redirectToOwner(c, client, spentTicket)
+		return nil
+	}
 
 	// Handle the WebSocket connection
 	for {
 		var msg models.Message
-		err := conn.ReadJSON(&msg)
+		err := client.ReadFrame(&msg)
 		if err != nil {
 			log.Printf("Error reading message: %v", err)
 			break
@@ -52,40 +95,203 @@ func (h *WebSocketHandler) HandleWebSocket(c echo.Context) error {
 
 		switch msg.Type {
 		case "new_client":
-			if h.hub.RegisterUser(msg.Sender, conn) {
-				response := models.Message{
-					Text:      "Registration successful",
+			if msg.Sender != client.User {
+				client.Send(models.Message{
+					Text:      "Sender does not match the authenticated user",
 					Sender:    "server",
 					Receiver:  msg.Sender,
-					Type:      "registration_success",
+					Type:      "registration_error",
 					Timestamp: time.Now(),
-				}
-				conn.WriteJSON(response)
+				})
+				continue
+			}
+			if !h.hub.Router().IsLocal(userKey) {
+				h.redirectToOwner(c, client, spentTicket)
+				return nil
+			}
 
-				// Fetch historical messages in goroutine
-				// go h.hub.FetchHistoricalMessages(msg.Sender)
-			} else {
-				response := models.Message{
-					Text:      "User already exists",
+			if err := h.hub.RegisterUser(msg.Sender, msg.DeviceID, client); err != nil {
+				text := "Registration failed"
+				switch {
+				case errors.Is(err, ws.ErrDuplicateSession):
+					text = "User already connected on this device"
+				case errors.Is(err, ws.ErrTenantFull):
+					text = "Too many connections for this tenant, try again later"
+				case errors.Is(err, ws.ErrTenantDisabled):
+					text = "Tenant disabled"
+				}
+				client.Send(models.Message{
+					Text:      text,
 					Sender:    "server",
 					Receiver:  msg.Sender,
 					Type:      "registration_error",
 					Timestamp: time.Now(),
-				}
-				conn.WriteJSON(response)
+				})
+				continue
+			}
+
+			response := models.Message{
+				Text:      "Registration successful",
+				Sender:    "server",
+				Receiver:  msg.Sender,
+				Type:      "registration_success",
+				Timestamp: time.Now(),
+			}
+			client.Send(response)
+
+			// Deliver anything queued while the user was offline
+			h.hub.FlushOfflineMessages(client)
+
+			// Fetch historical messages in goroutine, for tokens allowed to read them
+			if client.Scopes.Has(models.ScopeReadHistory) {
+				go h.hub.FetchHistoricalMessages(client.Tenant, client.ID, client.DeviceID)
 			}
 
 		case "chat":
-			// Send to chat channel for delivery
-			h.hub.SendToChat(msg)
-			// Send to persist channel for storage
-			h.hub.SendToPersist(msg)
+			if msg.Sender != client.User {
+				client.Send(models.Message{
+					Text:      "Sender does not match the authenticated user",
+					Sender:    "server",
+					Receiver:  client.User,
+					Type:      "message_error",
+					Timestamp: time.Now(),
+				})
+				continue
+			}
+			// Tag the originating device so the sender's other devices get a copy
+			msg.DeviceID = client.DeviceID
+			// Assign an ID, then queue for storage and delivery
+			h.hub.Submit(client, msg)
+
+		case "read":
+			if client.ID == "" {
+				continue
+			}
+			h.hub.MarkRead(client, msg.MessageID)
+
+		case "edit", "delete", "reaction_add", "reaction_remove":
+			h.hub.RequestChange(client, msg)
+
+		case "room", "room_create", "room_join", "room_invite", "room_leave", "room_members":
+			msg.DeviceID = client.DeviceID
+			h.hub.HandleRoomFrame(client, msg)
+
+		case "broadcast":
+			if client.ID == "" || !h.hub.IsAdmin(client.Tenant, client.ID) {
+				client.Send(models.Message{
+					Text:      "Not allowed to broadcast",
+					Sender:    "server",
+					Receiver:  client.ID,
+					Type:      "broadcast_error",
+					Timestamp: time.Now(),
+				})
+				continue
+			}
+			h.hub.Broadcast(client.Tenant, client.ID, msg.Text)
 
 		case "session_end":
-			h.hub.RemoveUser(msg.Sender)
+			// Only drop this connection, a newer one may have taken over the session
+			h.hub.UnregisterClient(client)
 			return nil
+
+		default:
+			// Typing indicators and other signals skip persistence entirely
+			if ws.IsEphemeral(msg.Type) {
+				h.hub.SendEphemeral(client, msg)
+			}
 		}
 	}
 
 	return nil
 }
+
+// redirectToOwner tells client to reconnect to the node that owns its user,
+// keeping the request's query. A ticket spent on this connection is replaced
+// with a fresh one for the owning node.
+func (h *WebSocketHandler) redirectToOwner(c echo.Context, client *ws.Client, spentTicket bool) {
+	_, addr := h.hub.Router().Owner(ws.UserKey(client.Tenant, client.User))
+	query := c.QueryParams()
+	if spentTicket {
+		query.Del("ticket")
+		fresh, _, err := h.hub.IssueTicket(c.Request().Context(), client.Tenant, client.User, client.Scopes)
+		if err != nil {
+			log.Printf("Error issuing a ticket to redirect %s: %v", client.User, err)
+		} else {
+			query.Set("ticket", fresh)
+		}
+	}
+	if len(query) > 0 {
+		addr += "?" + query.Encode()
+	}
+
+	client.ID = client.User
+	client.Redirect(addr)
+	// Let the write pump flush the redirect before closing
+	<-client.Done()
+}
+
+// StatsHandler reports send-queue pressure so lagging consumers can be
+// spotted. Platform keys see every tenant unless they name one.
+func (h *WebSocketHandler) StatsHandler(c echo.Context) error {
+	tenant := tenantOf(c)
+	if isPlatformKey(c) && c.Request().Header.Get(middleware.TenantHeader) == "" {
+		tenant = ""
+	}
+	return c.JSON(http.StatusOK, h.hub.Stats(tenant))
+}
+
+// TicketHandler issues a single-use ticket that lets a user of the caller's
+// tenant open one websocket connection with ?ticket=, for browsers that
+// can't send headers. API keys need the issue-tokens scope. The connection
+// holds no more than the caller's end-user scopes.
+func (h *WebSocketHandler) TicketHandler(c echo.Context) error {
+	type Request struct {
+		UserID string `json:"user_id" validate:"required"`
+	}
+
+	req := new(Request)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.UserID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "user_id is required")
+	}
+	if err := checkUser(c, req.UserID, models.ScopeIssueTokens); err != nil {
+		return err
+	}
+
+	ticket, expires, err := h.hub.IssueTicket(c.Request().Context(), tenantOf(c), req.UserID, callerScopes(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError,
+			fmt.Sprintf("Failed to issue ticket: %v", err))
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_at": expires,
+	})
+}
+
+// BroadcastHandler sends an announcement to every connected client of the
+// caller's tenant. Announcements always come from "server" so they can't be
+// passed off as a user's.
+func (h *WebSocketHandler) BroadcastHandler(c echo.Context) error {
+	type Request struct {
+		Text string `json:"text" validate:"required"`
+	}
+
+	req := new(Request)
+	if err := c.Bind(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	if req.Text == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "text is required")
+	}
+
+	msg := h.hub.Broadcast(tenantOf(c), "server", req.Text)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":    "broadcast",
+		"timestamp": msg.Timestamp,
+	})
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"chatsystem/internal/auth"
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// WebSocketAuthMiddleware requires a signed token before a websocket upgrade
+// and stores the tenant, user and scopes it was issued with on the context.
+// Browsers can't set headers on websocket requests, so they send a ?ticket=
+// that HandleWebSocket redeems instead. Tokens are never taken from the
+// query, where they would end up in access logs.
+func WebSocketAuthMiddleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			token := bearerToken(c.Request())
+			if token == "" {
+				// Single-use tickets are redeemed by the handler itself
+				if c.QueryParam("ticket") != "" {
+					return next(c)
+				}
+				return echo.NewHTTPError(http.StatusUnauthorized, "Missing token or ticket")
+			}
+
+			identity, err := auth.Authenticate(c.Request().Context(), token)
+			if errors.Is(err, auth.ErrTokenExpired) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Token expired")
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			}
+
+			c.Set(auth.UserContextKey, identity.User)
+			c.Set(auth.TenantContextKey, identity.Tenant)
+			c.Set(auth.ScopesContextKey, identity.Scopes)
+			return next(c)
+		}
+	}
+}
+
+// APIAuthMiddleware accepts either an API key, for trusted backends, or an
+// end user's bearer token. End users are recorded on the context so handlers
+// can keep them to their own data. Either way the request's tenant must exist
+// and be enabled.
+func APIAuthMiddleware(keys *services.APIKeyService, tenants *services.TenantService) echo.MiddlewareFunc {
+	apiKey := APIKeyMiddleware(keys)
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		withTenant := requireTenant(tenants, next)
+		withKey := apiKey(withTenant)
+		return func(c echo.Context) error {
+			token := bearerToken(c.Request())
+			if token == "" {
+				return withKey(c)
+			}
+
+			identity, err := auth.Authenticate(c.Request().Context(), token)
+			if errors.Is(err, auth.ErrTokenExpired) {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Token expired")
+			}
+			if err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Invalid token")
+			}
+
+			c.Set(auth.UserContextKey, identity.User)
+			c.Set(auth.TenantContextKey, identity.Tenant)
+			c.Set(auth.ScopesContextKey, identity.Scopes)
+			return withTenant(c)
+		}
+	}
+}
+
+// requireTenant refuses requests for tenants that don't exist or are disabled
+func requireTenant(tenants *services.TenantService, next echo.HandlerFunc) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		tenant, _ := c.Get(auth.TenantContextKey).(string)
+		settings, err := tenants.GetTenant(tenant)
+		if errors.Is(err, services.ErrTenantNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "Tenant not found")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Could not load tenant")
+		}
+		if settings.Disabled {
+			return echo.NewHTTPError(http.StatusForbidden, "Tenant disabled")
+		}
+		return next(c)
+	}
+}
+
+// RequireScope only lets through callers granted scope. End users hold the
+// scopes their token was minted with, and never admin.
+func RequireScope(scope string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			_, isUser := c.Get(auth.UserContextKey).(string)
+			if isUser && scope == models.ScopeAdmin {
+				return echo.NewHTTPError(http.StatusForbidden, "API key required")
+			}
+
+			scopes, _ := c.Get(auth.ScopesContextKey).(models.Scopes)
+			if !scopes.Has(scope) {
+				if isUser {
+					return echo.NewHTTPError(http.StatusForbidden, "Token lacks the "+scope+" scope")
+				}
+				return echo.NewHTTPError(http.StatusForbidden, "API key lacks the "+scope+" scope")
+			}
+			return next(c)
+		}
+	}
+}
+
+// PlatformOnly only lets through API keys that aren't bound to a tenant, for
+// endpoints that manage tenants themselves
+func PlatformOnly() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			_, isKey := c.Get(auth.APIKeyContextKey).(string)
+			if keyTenant, _ := c.Get(auth.KeyTenantContextKey).(string); !isKey || keyTenant != "" {
+				return echo.NewHTTPError(http.StatusForbidden, "Platform API key required")
+			}
+			return next(c)
+		}
+	}
+}
+
+// bearerToken returns the token from an "Authorization: Bearer" header
+func bearerToken(r *http.Request) string {
+	header := r.Header.Get("Authorization")
+	if len(header) > 7 && strings.EqualFold(header[:7], "bearer ") {
+		return strings.TrimSpace(header[7:])
+	}
+	return ""
+}
@@ -1,7 +1,13 @@
 package middleware
 
 import (
+	"chatsystem/internal/auth"
 	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"net/http"
 
 	"github.com/labstack/echo/v4"
@@ -21,16 +27,63 @@ func TrailMiddleware() echo.MiddlewareFunc {
 	return middleware.RemoveTrailingSlash()
 }
 
-func APIKeyMiddleware() echo.MiddlewareFunc {
-	expectedKey := config.AppConfig.APIKey
+// TenantHeader lets platform API keys choose the tenant a request acts within
+const TenantHeader = "X-Tenant-ID"
+
+// APIKeyMiddleware accepts the keys managed through the keys API, and the
+// API_KEY from the environment as a bootstrap platform admin key. The key's
+// scopes are stored on the context for RequireScope. Tenant keys act within
+// their own tenant, platform keys within the one named by X-Tenant-ID.
+func APIKeyMiddleware(keys *services.APIKeyService) echo.MiddlewareFunc {
+	bootstrapKey := sha256.Sum256([]byte(config.AppConfig.APIKey))
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			apiKey := c.Request().Header.Get("x-api-key")
-			if apiKey == "" || apiKey != expectedKey {
+			if apiKey == "" {
+				return echo.NewHTTPError(http.StatusUnauthorized, "Missing or invalid API key")
+			}
+
+			// Compare digests so the comparison doesn't leak the key's length
+			presented := sha256.Sum256([]byte(apiKey))
+			if subtle.ConstantTimeCompare(presented[:], bootstrapKey[:]) == 1 {
+				c.Set(auth.APIKeyContextKey, "bootstrap")
+				c.Set(auth.KeyTenantContextKey, "")
+				c.Set(auth.ScopesContextKey, models.Scopes{models.ScopeAdmin})
+				return withTenant(c, "", next)
+			}
+
+			key, err := keys.VerifyKey(apiKey)
+			if errors.Is(err, services.ErrInvalidAPIKey) {
 				return echo.NewHTTPError(http.StatusUnauthorized, "Missing or invalid API key")
 			}
-			return next(c)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "Could not verify API key")
+			}
+
+			c.Set(auth.APIKeyContextKey, key.ID)
+			c.Set(auth.KeyTenantContextKey, key.TenantID)
+			c.Set(auth.ScopesContextKey, key.Scopes)
+			return withTenant(c, key.TenantID, next)
 		}
 	}
 }
+
+// withTenant records the tenant an API key request acts within. A key bound
+// to a tenant can't be pointed at another one.
+func withTenant(c echo.Context, keyTenant string, next echo.HandlerFunc) error {
+	tenant := c.Request().Header.Get(TenantHeader)
+	switch {
+	case keyTenant != "" && tenant != "" && tenant != keyTenant:
+		return echo.NewHTTPError(http.StatusForbidden, "API key belongs to another tenant")
+	case keyTenant != "":
+		tenant = keyTenant
+	case tenant == "":
+		tenant = models.DefaultTenant
+	case !models.ValidTenantID(tenant):
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid tenant ID")
+	}
+
+	c.Set(auth.TenantContextKey, tenant)
+	return next(c)
+}
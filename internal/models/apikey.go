@@ -0,0 +1,80 @@
+package models
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// API key scopes
+const (
+	ScopeSend        = "send"         // Register users, send and receive messages, upload attachments
+	ScopeReadHistory = "read-history" // Read stored conversations
+	ScopeIssueTokens = "issue-tokens" // Mint tokens and websocket tickets for any user
+	ScopeAdmin       = "admin"        // Everything, including managing API keys
+)
+
+// UserScopes are the scopes an end user's token may carry. Users signed in
+// through the identity provider hold all of them.
+var UserScopes = Scopes{ScopeSend, ScopeReadHistory}
+
+// ValidScope reports whether scope is one we grant
+func ValidScope(scope string) bool {
+	switch scope {
+	case ScopeSend, ScopeReadHistory, ScopeIssueTokens, ScopeAdmin:
+		return true
+	}
+	return false
+}
+
+// Scopes is a list of scopes, stored as comma-separated text
+type Scopes []string
+
+func (s Scopes) Value() (driver.Value, error) {
+	return StringList(s).Value()
+}
+
+func (s *Scopes) Scan(value interface{}) error {
+	var list StringList
+	if err := list.Scan(value); err != nil {
+		return err
+	}
+	*s = Scopes(list)
+	return nil
+}
+
+// Has reports whether the scopes grant scope. Admin grants everything.
+func (s Scopes) Has(scope string) bool {
+	for _, granted := range s {
+		if granted == scope || granted == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// Delegated returns the scopes that tokens minted under s may carry: the
+// end-user scopes s grants, never issue-tokens or admin
+func (s Scopes) Delegated() Scopes {
+	delegated := Scopes{}
+	for _, scope := range UserScopes {
+		if s.Has(scope) {
+			delegated = append(delegated, scope)
+		}
+	}
+	return delegated
+}
+
+// APIKey is a credential for a service calling the REST API. Only a hash
+// of the secret part is stored.
+type APIKey struct {
+	ID         string     `gorm:"primaryKey;size:32" json:"id"`
+	Name       string     `gorm:"size:255;not null" json:"name"`
+	TenantID   string     `gorm:"size:64;not null;default:'';index" json:"tenant_id"` // Empty for platform keys, which may act for any tenant
+	Hash       []byte     `gorm:"not null" json:"-"`
+	Scopes     Scopes     `gorm:"type:text;not null" json:"scopes"`
+	CreatedBy  string     `gorm:"size:255" json:"created_by"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
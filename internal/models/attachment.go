@@ -0,0 +1,48 @@
+package models
+
+import "time"
+
+// Attachment is an uploaded file that chat messages can reference
+type Attachment struct {
+	ID          string    `gorm:"primaryKey;size:32" json:"id"`
+	TenantID    string    `gorm:"size:64;not null;default:'default';index" json:"tenant_id"`
+	Owner       string    `gorm:"size:255;not null;index" json:"owner"`
+	MessageID   *string   `gorm:"size:32;index" json:"message_id,omitempty"`
+	Name        string    `gorm:"size:255;not null" json:"name"`
+	ContentType string    `gorm:"size:255;not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	URL         string    `gorm:"type:text;not null" json:"url"`
+	StorageKey  string    `gorm:"type:text;not null" json:"-"`
+	Width       int       `json:"width,omitempty"`
+	Height      int       `json:"height,omitempty"`
+	ThumbURL    string    `gorm:"type:text" json:"thumbnail_url,omitempty"`
+	ThumbKey    string    `gorm:"type:text" json:"-"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// AttachmentRef is how a message carries an attachment on the wire. Clients
+// only need to send the ID, the server fills in the rest.
+type AttachmentRef struct {
+	ID          string `json:"id"`
+	Name        string `json:"name,omitempty"`
+	ContentType string `json:"content_type,omitempty"`
+	Size        int64  `json:"size,omitempty"`
+	URL         string `json:"url,omitempty"`
+	Width       int    `json:"width,omitempty"`
+	Height      int    `json:"height,omitempty"`
+	ThumbURL    string `json:"thumbnail_url,omitempty"`
+}
+
+// ToRef returns the wire form of an attachment
+func (a Attachment) ToRef() AttachmentRef {
+	return AttachmentRef{
+		ID:          a.ID,
+		Name:        a.Name,
+		ContentType: a.ContentType,
+		Size:        a.Size,
+		URL:         a.URL,
+		Width:       a.Width,
+		Height:      a.Height,
+		ThumbURL:    a.ThumbURL,
+	}
+}
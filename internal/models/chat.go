@@ -4,9 +4,121 @@ import "time"
 
 // Message represents a chat message
 type Message struct {
+	ID        string    `json:"id,omitempty"`
 	Text      string    `json:"text"`
 	Sender    string    `json:"sender"`
 	Receiver  string    `json:"receiver"`
 	Type      string    `json:"type"`
 	Timestamp time.Time `json:"timestamp"`
+	DeviceID  string    `json:"device_id,omitempty"`
+	RoomID    string    `json:"room_id,omitempty"`
+	Members   []string  `json:"members,omitempty"`
+	Open      bool      `json:"open,omitempty"`       // Room creation: anyone in the tenant may join
+	MessageID string    `json:"message_id,omitempty"` // Message a receipt refers to
+	Status    string    `json:"status,omitempty"`     // Delivery state, in history replay
+
+	IdempotencyKey string     `json:"idempotency_key,omitempty"` // Client-generated, deduplicates retried sends
+	EditedAt       *time.Time `json:"edited_at,omitempty"`
+	Deleted        bool       `json:"deleted,omitempty"` // Tombstone for a deleted message
+
+	Emoji     string            `json:"emoji,omitempty"`     // Reaction frames
+	Reactions []ReactionSummary `json:"reactions,omitempty"` // Aggregated reactions to the message
+
+	ParentID string         `json:"parent_id,omitempty"` // Message this one replies to
+	Quote    *QuotedMessage `json:"quote,omitempty"`     // Snapshot of the parent, filled in by the server
+
+	Attachments []AttachmentRef `json:"attachments,omitempty"`
+
+	Tenant string `json:"tenant,omitempty"` // Set by the server from the connection, never trusted from clients
+}
+
+// QuotedMessage is the part of a parent message shown alongside a reply
+type QuotedMessage struct {
+	ID      string `json:"id"`
+	Sender  string `json:"sender"`
+	Text    string `json:"text"`
+	Deleted bool   `json:"deleted,omitempty"`
+}
+
+// Delivery states of a persisted message
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryRead      = "read"
+)
+
+// ChatMessage is the persisted form of a Message
+type ChatMessage struct {
+	ID            uint       `gorm:"primaryKey" json:"-"`
+	MessageID     string     `gorm:"size:32;uniqueIndex" json:"id"`
+	TenantID      string     `gorm:"size:64;not null;default:'default';index" json:"tenant_id"`
+	Sender        string     `gorm:"size:255;not null;index" json:"sender"`
+	Receiver      string     `gorm:"size:255;not null;index" json:"receiver"`
+	RoomID        string     `gorm:"size:32;index" json:"room_id,omitempty"`
+	ParentID      string     `gorm:"size:32;index" json:"parent_id,omitempty"`
+	Type          string     `gorm:"size:50;not null" json:"type"`
+	Text          string     `gorm:"type:text" json:"text"`
+	Timestamp     time.Time  `gorm:"not null;index" json:"timestamp"`
+	DeliveryState string     `gorm:"size:20;not null;default:pending" json:"delivery_state"`
+	DeliveredAt   *time.Time `json:"delivered_at,omitempty"`
+	ReadAt        *time.Time `json:"read_at,omitempty"`
+	EditedAt      *time.Time `json:"edited_at,omitempty"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty"` // Tombstone, rows are never removed
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+// TableName overrides the default table name used by GORM
+func (ChatMessage) TableName() string {
+	return "messages"
+}
+
+// NewChatMessage builds the persisted form of a Message
+func NewChatMessage(msg Message) ChatMessage {
+	return ChatMessage{
+		MessageID:     msg.ID,
+		TenantID:      msg.Tenant,
+		Sender:        msg.Sender,
+		Receiver:      msg.Receiver,
+		RoomID:        msg.RoomID,
+		ParentID:      msg.ParentID,
+		Type:          msg.Type,
+		Text:          msg.Text,
+		Timestamp:     msg.Timestamp,
+		DeliveryState: DeliveryPending,
+	}
+}
+
+// ToMessage converts a persisted message back into its wire form.
+// Deleted messages come back as tombstones without their text.
+func (m ChatMessage) ToMessage() Message {
+	msg := Message{
+		ID:        m.MessageID,
+		Text:      m.Text,
+		Sender:    m.Sender,
+		Receiver:  m.Receiver,
+		RoomID:    m.RoomID,
+		ParentID:  m.ParentID,
+		Type:      m.Type,
+		Timestamp: m.Timestamp,
+		Status:    m.DeliveryState,
+		EditedAt:  m.EditedAt,
+		Tenant:    m.TenantID,
+	}
+	if m.DeletedAt != nil {
+		msg.Text = ""
+		msg.Deleted = true
+	}
+	return msg
+}
+
+// ToQuote returns the snapshot of this message shown alongside replies
+func (m ChatMessage) ToQuote() *QuotedMessage {
+	msg := m.ToMessage()
+	return &QuotedMessage{
+		ID:      msg.ID,
+		Sender:  msg.Sender,
+		Text:    msg.Text,
+		Deleted: msg.Deleted,
+	}
 }
@@ -0,0 +1,14 @@
+package models
+
+// All returns every persisted model, in the order they are migrated
+func All() []interface{} {
+	return []interface{}{
+		&ChatMessage{},
+		&Room{},
+		&RoomMember{},
+		&MessageReaction{},
+		&Attachment{},
+		&APIKey{},
+		&Tenant{},
+	}
+}
@@ -0,0 +1,19 @@
+package models
+
+import "time"
+
+// MessageReaction records one user's emoji reaction to a message
+type MessageReaction struct {
+	MessageID string    `gorm:"primaryKey;size:32" json:"message_id"`
+	TenantID  string    `gorm:"size:64;not null;default:'default';index" json:"tenant_id"`
+	UserID    string    `gorm:"primaryKey;size:255" json:"user_id"`
+	Emoji     string    `gorm:"primaryKey;size:32" json:"emoji"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ReactionSummary aggregates the reactions to a message for one emoji
+type ReactionSummary struct {
+	Emoji string   `json:"emoji"`
+	Count int      `json:"count"`
+	Users []string `json:"users"`
+}
@@ -0,0 +1,22 @@
+package models
+
+import "time"
+
+// Room is a group conversation
+type Room struct {
+	ID        string    `gorm:"primaryKey;size:32" json:"id"`
+	TenantID  string    `gorm:"size:64;not null;default:'default';index" json:"tenant_id"`
+	Name      string    `gorm:"size:255;not null" json:"name"`
+	CreatedBy string    `gorm:"size:255;not null" json:"created_by"`
+	Open      bool      `gorm:"not null;default:false" json:"open"` // Anyone in the tenant may join, otherwise members are invited by the creator
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// RoomMember records that a user belongs to a room. Members only see the
+// room's messages from JoinedAt on.
+type RoomMember struct {
+	RoomID   string    `gorm:"primaryKey;size:32" json:"room_id"`
+	UserID   string    `gorm:"primaryKey;size:255;index" json:"user_id"`
+	TenantID string    `gorm:"size:64;not null;default:'default';index" json:"tenant_id"`
+	JoinedAt time.Time `json:"joined_at"`
+}
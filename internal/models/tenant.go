@@ -0,0 +1,74 @@
+package models
+
+import (
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// DefaultTenant owns data created before tenants existed, and is used by
+// platform callers that don't name a tenant
+const DefaultTenant = "default"
+
+// tenantIDPattern keeps tenant IDs safe to embed in Redis keys. They can't
+// contain ':', so a tenant-qualified key can never be mistaken for another.
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidTenantID reports whether id can be used as a tenant ID
+func ValidTenantID(id string) bool {
+	return tenantIDPattern.MatchString(id)
+}
+
+// Tenant is a storefront hosted on the chat server. Zero limits fall back to
+// the server-wide configuration.
+type Tenant struct {
+	ID                string     `gorm:"primaryKey;size:64" json:"id"`
+	Name              string     `gorm:"size:255;not null" json:"name"`
+	Disabled          bool       `gorm:"not null;default:false" json:"disabled"`
+	MaxConnections    int        `gorm:"not null;default:0" json:"max_connections"`     // Per node, 0 is unlimited
+	MessagesPerMinute int        `gorm:"not null;default:0" json:"messages_per_minute"` // Across the cluster, 0 is unlimited
+	HistoryLimit      int        `gorm:"not null;default:0" json:"history_limit"`       // 0 uses CHAT_HISTORY_LIMIT
+	MaxUploadSize     int64      `gorm:"not null;default:0" json:"max_upload_size"`     // 0 uses MAX_UPLOAD_SIZE
+	AdminUsers        StringList `gorm:"type:text;not null;default:''" json:"admin_users"`
+	CreatedAt         time.Time  `json:"created_at"`
+	UpdatedAt         time.Time  `json:"updated_at"`
+}
+
+// StringList is a list of strings stored as comma-separated text
+type StringList []string
+
+func (l StringList) Value() (driver.Value, error) {
+	return strings.Join(l, ","), nil
+}
+
+func (l *StringList) Scan(value interface{}) error {
+	var raw string
+	switch v := value.(type) {
+	case string:
+		raw = v
+	case []byte:
+		raw = string(v)
+	case nil:
+	default:
+		return fmt.Errorf("cannot scan %T into a string list", value)
+	}
+	*l = nil
+	for _, item := range strings.Split(raw, ",") {
+		if item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// Contains reports whether the list holds item
+func (l StringList) Contains(item string) bool {
+	for _, existing := range l {
+		if existing == item {
+			return true
+		}
+	}
+	return false
+}
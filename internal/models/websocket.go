@@ -1,16 +1,9 @@
 package models
 
-
-
-
-
-// PersistMessage represents message for persistence
-type PersistMessage struct {
-	Receiver string `json:"receiver"`
-	Message  string `json:"message"`
-}
-
 // QueryMessage represents query for historical messages
 type QueryMessage struct {
-	UserID string `json:"user_id"`
+	Tenant   string `json:"tenant"`
+	UserID   string `json:"user_id"`
+	DeviceID string `json:"device_id,omitempty"`
+	Limit    int    `json:"limit"`
 }
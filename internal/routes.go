@@ -1,25 +1,34 @@
 package internal
 
 import (
+	"chatsystem/internal/config"
 	"chatsystem/internal/handlers"
 	app_midd "chatsystem/internal/middleware"
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
 	ws "chatsystem/internal/websocket"
+	"chatsystem/pkg/storage"
 
 	"github.com/labstack/echo/v4"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
 )
 
-func SetupWebSocketRoutes(e *echo.Echo, db *gorm.DB, rdb *redis.Client) {
-	hub := ws.NewHub()
+func SetupWebSocketRoutes(e *echo.Echo, db *gorm.DB, rdb *redis.Client, tenants *services.TenantService) *ws.Hub {
+	hub := ws.NewHub(services.NewChatService(db), services.NewRoomService(db), tenants, rdb)
 	wsHandler := handlers.NewWebSocketHandler(hub)
 	// Start goroutines to process channels
 	go hub.ProcessChatMessages()
 	go hub.ProcessPersistMessages()
-	e.GET("/ws/server", wsHandler.HandleWebSocket)
+	go hub.ProcessHistoryRequests()
+	go hub.ProcessRemoteMessages()
+	go hub.MaintainNodePresence()
+	go hub.Router().Run()
+	e.GET("/ws/server", wsHandler.HandleWebSocket, app_midd.WebSocketAuthMiddleware())
+	return hub
 }
 
-func ApiRoutes(e *echo.Group, db *gorm.DB, rdb *redis.Client) {
+func ApiRoutes(e *echo.Group, db *gorm.DB, rdb *redis.Client, hub *ws.Hub, blobs storage.BlobStore, tenants *services.TenantService) {
 	e.Use(app_midd.Recover)
 	// e.Use(app_midd.SetHeaders)
 
@@ -27,10 +36,36 @@ func ApiRoutes(e *echo.Group, db *gorm.DB, rdb *redis.Client) {
 
 	// Initialize handlers
 	chatHandler := handlers.NewWebSocketChatHandler(db, rdb)
+	wsHandler := handlers.NewWebSocketHandler(hub)
+	attachmentHandler := handlers.NewAttachmentHandler(services.NewAttachmentService(db, blobs, config.AppConfig.ThumbnailSize, config.AppConfig.ImageWorkers), tenants)
+
+	keyHandler := handlers.NewAPIKeyHandler(services.NewAPIKeyService(db))
+	tenantHandler := handlers.NewTenantHandler(tenants)
+
+	send := app_midd.RequireScope(models.ScopeSend)
+	readHistory := app_midd.RequireScope(models.ScopeReadHistory)
+	admin := app_midd.RequireScope(models.ScopeAdmin)
 
 	// Define routes
-	chatGroup.POST("/register", chatHandler.RegisterHandler)
-	chatGroup.POST("/send", chatHandler.SendHandler)
-	chatGroup.GET("/listen/:userID", chatHandler.ListenHandler)
-	chatGroup.DELETE("/disconnect/:userID", chatHandler.DisconnectHandler)
+	chatGroup.POST("/register", chatHandler.RegisterHandler, send)
+	chatGroup.POST("/token", chatHandler.TokenHandler, send)
+	chatGroup.POST("/ticket", wsHandler.TicketHandler, send)
+	chatGroup.POST("/send", chatHandler.SendHandler, send)
+	chatGroup.GET("/listen/:userID", chatHandler.ListenHandler, send)
+	chatGroup.DELETE("/disconnect/:userID", chatHandler.DisconnectHandler, send)
+	chatGroup.GET("/messages/:messageID/thread", chatHandler.ThreadHandler, readHistory)
+	chatGroup.POST("/attachments", attachmentHandler.UploadHandler, send)
+	chatGroup.GET("/attachments/:attachmentID", attachmentHandler.DownloadHandler, readHistory)
+	chatGroup.GET("/attachments/:attachmentID/thumbnail", attachmentHandler.ThumbnailHandler, readHistory)
+	chatGroup.GET("/stats", wsHandler.StatsHandler, admin)
+	chatGroup.POST("/broadcast", wsHandler.BroadcastHandler, admin)
+
+	keyGroup := e.Group("v1/keys", admin)
+	keyGroup.POST("", keyHandler.CreateHandler)
+	keyGroup.GET("", keyHandler.ListHandler)
+	keyGroup.DELETE("/:keyID", keyHandler.RevokeHandler)
+
+	tenantGroup := e.Group("v1/tenants", admin, app_midd.PlatformOnly())
+	tenantGroup.GET("", tenantHandler.ListHandler)
+	tenantGroup.PUT("/:tenantID", tenantHandler.SaveHandler)
 }
@@ -1,9 +1,12 @@
 package internal
 
 import (
+	"chatsystem/internal/auth"
 	"chatsystem/internal/config"
 	"chatsystem/internal/middleware"
+	"chatsystem/internal/services"
 	"chatsystem/pkg/database"
+	"chatsystem/pkg/storage"
 	"context"
 	"log"
 	"net/http"
@@ -15,6 +18,9 @@ import (
 
 func Start() *echo.Echo {
 	log.Println("🟢🔧 Starting Risigner Chat Server")
+	if err := config.Load(); err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
 	// Initialize database connection
 	db, err := database.ConnectDB()
 	if err != nil {
@@ -24,6 +30,13 @@ func Start() *echo.Echo {
 	if err != nil {
 		log.Fatalf("Failed to connect to redis database: %v", err)
 	}
+	blobs, err := storage.ConnectBlobStore()
+	if err != nil {
+		log.Fatalf("Failed to set up blob store: %v", err)
+	}
+	if err := auth.ConnectOIDC(context.Background()); err != nil {
+		log.Fatalf("Failed to load OIDC keys: %v", err)
+	}
 	e := echo.New()
 	//CORS & Middleware
 	e.Use(middleware.CORSMiddleware())
@@ -56,8 +69,9 @@ func Start() *echo.Echo {
 	//set api endpoint
 	api := e.Group("api/")
 
-	api.Use(middleware.APIKeyMiddleware())
-	SetupWebSocketRoutes(e, db, redisdb)
+	tenants := services.NewTenantService(db)
+	api.Use(middleware.APIAuthMiddleware(services.NewAPIKeyService(db), tenants))
+	hub := SetupWebSocketRoutes(e, db, redisdb, tenants)
 	//Run Server
 	s := &http.Server{
 		Addr:         ":" + string(config.AppConfig.PORT),
@@ -76,7 +90,7 @@ func Start() *echo.Echo {
 	}()
 	log.Println("⚡️🚀 Risigner Chat Server::Started")
 	log.Println("⚡️🚀 Risigner Chat Server::Running")
-	ApiRoutes(api, db, redisdb)
+	ApiRoutes(api, db, redisdb, hub, blobs, tenants)
 	return e
 }
 
@@ -0,0 +1,162 @@
+package services
+
+import (
+	"chatsystem/internal/models"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// apiKeyPrefix marks our keys so they are easy to recognise, e.g. in leaked logs
+const apiKeyPrefix = "csk_"
+
+// lastUsedResolution limits how often verifying a key writes its last-used time
+const lastUsedResolution = time.Minute
+
+var (
+	ErrAPIKeyNotFound = errors.New("API key not found")
+	ErrInvalidAPIKey  = errors.New("invalid API key")
+	ErrInvalidScope   = errors.New("invalid scope")
+)
+
+type APIKeyService struct {
+	db *gorm.DB
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{
+		db: db,
+	}
+}
+
+// CreateKey stores a new key and returns it along with the full key string,
+// which is shown once and can't be recovered afterwards. Keys look like
+// csk_<id>_<secret>, the ID lets us find the key without scanning hashes.
+// Keys with an empty tenantID are platform keys that may act for any tenant.
+func (s *APIKeyService) CreateKey(tenantID, name string, scopes []string, expiresAt *time.Time, createdBy string) (*models.APIKey, string, error) {
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	for _, scope := range scopes {
+		if !models.ValidScope(scope) {
+			return nil, "", ErrInvalidScope
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(encoded))
+
+	key := models.APIKey{
+		ID:        NewID(),
+		TenantID:  tenantID,
+		Name:      name,
+		Hash:      hash[:],
+		Scopes:    scopes,
+		CreatedBy: createdBy,
+		ExpiresAt: expiresAt,
+	}
+	if err := s.db.Create(&key).Error; err != nil {
+		return nil, "", err
+	}
+	return &key, apiKeyPrefix + key.ID + "_" + encoded, nil
+}
+
+// ListKeys returns every key, newest first. Hashes are never serialised.
+func (s *APIKeyService) ListKeys() ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// ListTenantKeys returns the keys belonging to one tenant, newest first
+func (s *APIKeyService) ListTenantKeys(tenantID string) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	err := s.db.Where("tenant_id = ?", tenantID).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// GetKey loads a key by its ID
+func (s *APIKeyService) GetKey(id string) (*models.APIKey, error) {
+	var key models.APIKey
+	err := s.db.Where("id = ?", id).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAPIKeyNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// RevokeKey stops a key from being accepted
+func (s *APIKeyService) RevokeKey(id string) (*models.APIKey, error) {
+	key, err := s.GetKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if key.RevokedAt != nil {
+		return key, nil
+	}
+
+	now := time.Now()
+	if err := s.db.Model(key).Update("revoked_at", now).Error; err != nil {
+		return nil, err
+	}
+	key.RevokedAt = &now
+	return key, nil
+}
+
+// VerifyKey checks a key string and returns the key it belongs to. The secret
+// is compared in constant time, and revoked or expired keys are refused.
+func (s *APIKeyService) VerifyKey(raw string) (*models.APIKey, error) {
+	id, secret, ok := parseAPIKey(raw)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var key models.APIKey
+	err := s.db.Where("id = ?", id).First(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], key.Hash) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+	now := time.Now()
+	if key.RevokedAt != nil || (key.ExpiresAt != nil && !now.Before(*key.ExpiresAt)) {
+		return nil, ErrInvalidAPIKey
+	}
+
+	// Only write when the recorded time is noticeably stale
+	err = s.db.Model(&models.APIKey{}).
+		Where("id = ? AND (last_used_at IS NULL OR last_used_at < ?)", key.ID, now.Add(-lastUsedResolution)).
+		Update("last_used_at", now).Error
+	if err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// parseAPIKey splits csk_<id>_<secret> into its ID and secret
+func parseAPIKey(raw string) (string, string, bool) {
+	rest, ok := strings.CutPrefix(raw, apiKeyPrefix)
+	if !ok || len(rest) < 34 || rest[32] != '_' {
+		return "", "", false
+	}
+	return rest[:32], rest[33:], true
+}
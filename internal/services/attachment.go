@@ -0,0 +1,215 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"chatsystem/internal/models"
+	"chatsystem/pkg/media"
+	"chatsystem/pkg/storage"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// AttachmentURLPrefix is where the API serves attachments kept in private blob stores
+const AttachmentURLPrefix = "/api/v1/chat/attachments/"
+
+var (
+	ErrAttachmentNotFound = errors.New("attachment not found")
+	ErrAttachmentInUse    = errors.New("attachment already belongs to another message")
+	ErrInvalidImage       = errors.New("invalid image")
+	ErrUnsupportedType    = errors.New("unsupported file type")
+	ErrPublicBlob         = errors.New("attachment is served by the blob store")
+)
+
+// allowedContentTypes are the sniffed types accepted as attachments, and the
+// extension each is stored under whatever the client called the file.
+// Types a browser could run as active content, like HTML or SVG, aren't listed.
+// Nor is WebP, which can carry EXIF and XMP that media.Process can't strip.
+var allowedContentTypes = map[string]string{
+	"image/jpeg":                ".jpg",
+	"image/png":                 ".png",
+	"image/gif":                 ".gif",
+	"application/pdf":           ".pdf",
+	"text/plain; charset=utf-8": ".txt",
+	"application/zip":           ".zip", // Also Office documents, which are zip archives
+}
+
+// unsafeNameChars matches anything we don't keep in stored file names
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+type AttachmentService struct {
+	db            *gorm.DB
+	store         storage.BlobStore
+	thumbnailSize int
+	imageSlots    chan struct{} // Bounds how many images are held and decoded at once
+}
+
+func NewAttachmentService(db *gorm.DB, store storage.BlobStore, thumbnailSize, imageWorkers int) *AttachmentService {
+	return &AttachmentService{
+		db:            db,
+		store:         store,
+		thumbnailSize: thumbnailSize,
+		imageSlots:    make(chan struct{}, max(1, imageWorkers)),
+	}
+}
+
+// Upload stores a file in the blob store and records it as an attachment
+// owned by owner within tenant
+func (s *AttachmentService) Upload(ctx context.Context, tenant, owner, name string, size int64, body io.Reader) (*models.Attachment, error) {
+	// Sniff the content type instead of trusting the client
+	buffered := bufio.NewReaderSize(body, 512)
+	head, err := buffered.Peek(512)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return nil, err
+	}
+	contentType := http.DetectContentType(head)
+	ext, allowed := allowedContentTypes[contentType]
+	if !allowed {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedType, contentType)
+	}
+
+	attachment := models.Attachment{
+		ID:          NewID(),
+		TenantID:    tenant,
+		Owner:       owner,
+		Name:        path.Base(name),
+		ContentType: contentType,
+		Size:        size,
+	}
+	attachment.StorageKey = "attachments/" + tenant + "/" + attachment.ID + "/" + safeFileName(name, ext)
+
+	var content io.Reader = buffered
+	if media.IsImage(contentType) {
+		// Images are small enough to hold in memory, and need to be read
+		// whole to strip their metadata and render a thumbnail. Only a few
+		// are processed at once so concurrent uploads can't exhaust memory.
+		select {
+		case s.imageSlots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		defer func() { <-s.imageSlots }()
+
+		data, err := io.ReadAll(buffered)
+		if err != nil {
+			return nil, err
+		}
+		img, err := media.Process(contentType, data, s.thumbnailSize)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidImage, err)
+		}
+
+		attachment.Size = int64(len(img.Data))
+		attachment.Width = img.Width
+		attachment.Height = img.Height
+		attachment.ThumbKey = "attachments/" + tenant + "/" + attachment.ID + "/thumbnail" + thumbnailExt(img.ThumbnailType)
+		attachment.ThumbURL, err = s.store.Put(ctx, attachment.ThumbKey, img.ThumbnailType, bytes.NewReader(img.Thumbnail))
+		if err != nil {
+			return nil, err
+		}
+		content = bytes.NewReader(img.Data)
+	}
+
+	attachment.URL, err = s.store.Put(ctx, attachment.StorageKey, contentType, content)
+	if err != nil {
+		return nil, err
+	}
+	// Private stores are read back through the API, which checks access
+	if _, private := s.store.(storage.BlobReader); private {
+		attachment.URL = AttachmentURLPrefix + attachment.ID
+		if attachment.ThumbKey != "" {
+			attachment.ThumbURL = attachment.URL + "/thumbnail"
+		}
+	}
+	if err := s.db.Create(&attachment).Error; err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// GetAttachment loads one of tenant's attachments
+func (s *AttachmentService) GetAttachment(tenant, id string) (*models.Attachment, error) {
+	var attachment models.Attachment
+	err := s.db.Where("tenant_id = ? AND id = ?", tenant, id).First(&attachment).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// CanView reports whether userID may download an attachment: its owner, or
+// anyone taking part in the conversation it was sent to
+func (s *AttachmentService) CanView(attachment *models.Attachment, userID string) (bool, error) {
+	if attachment.Owner == userID {
+		return true, nil
+	}
+	if attachment.MessageID == nil {
+		return false, nil
+	}
+
+	chat := NewChatService(s.db).ForTenant(attachment.TenantID)
+	record, err := chat.GetMessage(*attachment.MessageID)
+	if errors.Is(err, ErrMessageNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return chat.IsParticipant(record, userID)
+}
+
+// Open reads an attachment, or its thumbnail, back from a private blob store.
+// It returns ErrPublicBlob when the store serves the file itself.
+func (s *AttachmentService) Open(ctx context.Context, attachment *models.Attachment, thumbnail bool) (io.ReadSeekCloser, error) {
+	reader, private := s.store.(storage.BlobReader)
+	if !private {
+		return nil, ErrPublicBlob
+	}
+	key := attachment.StorageKey
+	if thumbnail {
+		if attachment.ThumbKey == "" {
+			return nil, ErrAttachmentNotFound
+		}
+		key = attachment.ThumbKey
+	}
+	return reader.Open(ctx, key)
+}
+
+// thumbnailExt returns the file extension for a thumbnail content type
+func thumbnailExt(contentType string) string {
+	if contentType == "image/jpeg" {
+		return ".jpg"
+	}
+	return ".png"
+}
+
+// ThumbnailType returns the content type of an attachment's thumbnail
+func ThumbnailType(attachment *models.Attachment) string {
+	if strings.HasSuffix(attachment.ThumbKey, ".jpg") {
+		return "image/jpeg"
+	}
+	return "image/png"
+}
+
+// safeFileName reduces a client-supplied file name to something safe to use
+// in a blob key, with ext in place of whatever extension the client gave
+func safeFileName(name, ext string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = strings.Trim(unsafeNameChars.ReplaceAllString(name, "_"), "._")
+	if name == "" {
+		name = "file"
+	}
+	return name + ext
+}
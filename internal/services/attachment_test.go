@@ -0,0 +1,150 @@
+package services
+
+import (
+	"bytes"
+	"chatsystem/internal/models"
+	"chatsystem/pkg/storage"
+	"context"
+	"errors"
+	"image"
+	"image/png"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAttachments(t *testing.T) (*AttachmentService, *ChatService) {
+	t.Helper()
+	db := newTestDB(t)
+	store, err := storage.NewLocalStore(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStore: %v", err)
+	}
+	return NewAttachmentService(db, store, 32, 1), NewChatService(db)
+}
+
+func upload(t *testing.T, attachments *AttachmentService, owner, name string, data []byte) (*models.Attachment, error) {
+	t.Helper()
+	return attachments.Upload(context.Background(), models.DefaultTenant, owner, name, int64(len(data)), bytes.NewReader(data))
+}
+
+func testPNG(t *testing.T) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 64, 48))); err != nil {
+		t.Fatalf("encoding png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestUploadRefusesActiveContent(t *testing.T) {
+	attachments, _ := newTestAttachments(t)
+
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"page.html", "<!DOCTYPE html><script>alert(1)</script>"},
+		{"image.svg", `<?xml version="1.0"?><svg xmlns="http://www.w3.org/2000/svg"><script>alert(1)</script></svg>`},
+		{"notes.txt", "<html><body>still html</body></html>"},
+	}
+	for _, tt := range tests {
+		_, err := upload(t, attachments, "alice", tt.name, []byte(tt.data))
+		if !errors.Is(err, ErrUnsupportedType) {
+			t.Errorf("Upload(%s) = %v, want ErrUnsupportedType", tt.name, err)
+		}
+	}
+}
+
+func TestUploadRefusesWebP(t *testing.T) {
+	attachments, _ := newTestAttachments(t)
+	// A WebP carrying an EXIF chunk we have no way to scrub
+	data := []byte("RIFF\x1c\x00\x00\x00WEBPVP8X\x0a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00\x00\x00\x00EXIF\x00\x00\x00\x00")
+
+	_, err := upload(t, attachments, "alice", "photo.webp", data)
+	if !errors.Is(err, ErrUnsupportedType) {
+		t.Errorf("Upload = %v, want ErrUnsupportedType", err)
+	}
+}
+
+func TestUploadStoresUnderSniffedExtension(t *testing.T) {
+	attachments, _ := newTestAttachments(t)
+
+	attachment, err := upload(t, attachments, "alice", "photo.html", testPNG(t))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+	if !strings.HasSuffix(attachment.StorageKey, "/photo.png") {
+		t.Errorf("storage key = %q, want the .png extension", attachment.StorageKey)
+	}
+	if attachment.Name != "photo.html" {
+		t.Errorf("name = %q, want the client's name kept for display", attachment.Name)
+	}
+	if attachment.URL != AttachmentURLPrefix+attachment.ID {
+		t.Errorf("URL = %q, want the API download URL", attachment.URL)
+	}
+	if attachment.ThumbURL != attachment.URL+"/thumbnail" {
+		t.Errorf("thumbnail URL = %q", attachment.ThumbURL)
+	}
+
+	for _, thumbnail := range []bool{false, true} {
+		file, err := attachments.Open(context.Background(), attachment, thumbnail)
+		if err != nil {
+			t.Fatalf("Open(thumbnail=%v): %v", thumbnail, err)
+		}
+		data, err := io.ReadAll(file)
+		file.Close()
+		if err != nil || len(data) == 0 {
+			t.Errorf("Open(thumbnail=%v) read %d bytes, %v", thumbnail, len(data), err)
+		}
+	}
+}
+
+func TestAttachmentAccess(t *testing.T) {
+	attachments, chat := newTestAttachments(t)
+
+	attachment, err := upload(t, attachments, "alice", "notes.txt", []byte("plain notes"))
+	if err != nil {
+		t.Fatalf("Upload: %v", err)
+	}
+
+	if _, err := attachments.GetAttachment("globex", attachment.ID); !errors.Is(err, ErrAttachmentNotFound) {
+		t.Errorf("GetAttachment from another tenant = %v, want ErrAttachmentNotFound", err)
+	}
+
+	canView := func(user string) bool {
+		t.Helper()
+		stored, err := attachments.GetAttachment(models.DefaultTenant, attachment.ID)
+		if err != nil {
+			t.Fatalf("GetAttachment: %v", err)
+		}
+		allowed, err := attachments.CanView(stored, user)
+		if err != nil {
+			t.Fatalf("CanView(%s): %v", user, err)
+		}
+		return allowed
+	}
+
+	if !canView("alice") || canView("bob") {
+		t.Fatal("only the owner should see an unsent attachment")
+	}
+
+	err = chat.SaveMessage(models.Message{
+		ID:          "m1",
+		Sender:      "alice",
+		Receiver:    "bob",
+		Type:        "chat",
+		Timestamp:   time.Now(),
+		Attachments: []models.AttachmentRef{{ID: attachment.ID}},
+	})
+	if err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+	if !canView("bob") {
+		t.Error("the receiver should see a sent attachment")
+	}
+	if canView("carol") {
+		t.Error("users outside the conversation shouldn't see the attachment")
+	}
+}
@@ -2,47 +2,287 @@ package services
 
 import (
 	"chatsystem/internal/models"
-	"encoding/json"
-	"log"
-	"net/rpc"
+	"errors"
+	"fmt"
+	"time"
 
 	"gorm.io/gorm"
 )
 
+// defaultHistoryLimit bounds history queries that do not specify a limit
+const defaultHistoryLimit = 50
+
+var (
+	ErrMessageNotFound = errors.New("message not found")
+	ErrNotSender       = errors.New("only the sender can change a message")
+	ErrMessageDeleted  = errors.New("message has been deleted")
+	ErrInvalidParent   = errors.New("parent message is not in this conversation")
+)
+
 type ChatService struct {
-	db *gorm.DB
+	db     *gorm.DB
+	tenant string
 }
 
 func NewChatService(db *gorm.DB) *ChatService {
 	return &ChatService{
-		db: db,
+		db:     db,
+		tenant: models.DefaultTenant,
+	}
+}
+
+// ForTenant returns a ChatService that only reads and writes tenant's data
+func (s *ChatService) ForTenant(tenant string) *ChatService {
+	return &ChatService{
+		db:     s.db,
+		tenant: tenant,
 	}
 }
 
-// Get chat between a user and creator
+// scoped starts a query limited to the service's tenant
+func (s *ChatService) scoped() *gorm.DB {
+	return s.db.Where("tenant_id = ?", s.tenant)
+}
+
+// SaveMessage stores a chat message in the messages table
 func (s *ChatService) SaveMessage(msg models.Message) error {
-	return nil
+	if msg.Timestamp.IsZero() {
+		msg.Timestamp = time.Now()
+	}
+	if msg.ID == "" {
+		msg.ID = NewID()
+	}
+	record := models.NewChatMessage(msg)
+	record.TenantID = s.tenant
+	if len(msg.Attachments) == 0 {
+		return s.db.Create(&record).Error
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&record).Error; err != nil {
+			return err
+		}
+		ids := make([]string, 0, len(msg.Attachments))
+		for _, attachment := range msg.Attachments {
+			ids = append(ids, attachment.ID)
+		}
+		return tx.Model(&models.Attachment{}).
+			Where("tenant_id = ? AND id IN ? AND owner = ? AND message_id IS NULL", s.tenant, ids, msg.Sender).
+			Update("message_id", msg.ID).Error
+	})
+}
+
+// ResolveAttachments checks that every referenced attachment was uploaded by
+// owner and is not yet part of a message, and returns the stored details
+func (s *ChatService) ResolveAttachments(owner string, refs []models.AttachmentRef) ([]models.AttachmentRef, error) {
+	if len(refs) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		ids = append(ids, ref.ID)
+	}
+
+	var attachments []models.Attachment
+	if err := s.scoped().Where("id IN ? AND owner = ?", ids, owner).Find(&attachments).Error; err != nil {
+		return nil, err
+	}
+	byID := make(map[string]models.Attachment, len(attachments))
+	for _, attachment := range attachments {
+		byID[attachment.ID] = attachment
+	}
+
+	resolved := make([]models.AttachmentRef, 0, len(refs))
+	for _, ref := range refs {
+		attachment, exists := byID[ref.ID]
+		if !exists {
+			return nil, ErrAttachmentNotFound
+		}
+		if attachment.MessageID != nil {
+			return nil, ErrAttachmentInUse
+		}
+		resolved = append(resolved, attachment.ToRef())
+	}
+	return resolved, nil
+}
+
+// GetAttachments returns the attachments of each of the given messages
+func (s *ChatService) GetAttachments(messageIDs []string) (map[string][]models.AttachmentRef, error) {
+	refs := make(map[string][]models.AttachmentRef)
+	if len(messageIDs) == 0 {
+		return refs, nil
+	}
+
+	var attachments []models.Attachment
+	err := s.scoped().Where("message_id IN ?", messageIDs).Order("created_at").Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, attachment := range attachments {
+		refs[*attachment.MessageID] = append(refs[*attachment.MessageID], attachment.ToRef())
+	}
+	return refs, nil
+}
+
+// GetMessages returns the latest messages sent or received by a user,
+// including messages in rooms they belong to, oldest first
+func (s *ChatService) GetMessages(query models.QueryMessage) ([]models.ChatMessage, error) {
+	limit := query.Limit
+	if limit <= 0 {
+		limit = defaultHistoryLimit
+	}
+
+	// Room messages only count from when the user joined the room
+	joined := s.db.Model(&models.RoomMember{}).Select("1").Where(
+		"room_members.tenant_id = messages.tenant_id AND room_members.room_id = messages.room_id"+
+			" AND room_members.user_id = ? AND room_members.joined_at <= messages.timestamp", query.UserID)
+
+	var records []models.ChatMessage
+	err := s.scoped().
+		Where("sender = ? OR receiver = ? OR EXISTS (?)", query.UserID, query.UserID, joined).
+		Order("timestamp DESC").
+		Order("id DESC").
+		Limit(limit).
+		Find(&records).Error
+	if err != nil {
+		return nil, err
+	}
 
+	// Reverse so callers can replay in chronological order
+	for i, j := 0, len(records)-1; i < j; i, j = i+1, j-1 {
+		records[i], records[j] = records[j], records[i]
+	}
+	return records, nil
+}
+
+// GetMessage loads a stored message by its server-assigned ID
+func (s *ChatService) GetMessage(messageID string) (*models.ChatMessage, error) {
+	var record models.ChatMessage
+	err := s.scoped().Where("message_id = ?", messageID).First(&record).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrMessageNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// UpdateDeliveryState moves a message forward to delivered or read. States
+// never move backwards, so late or duplicate receipts are harmless.
+func (s *ChatService) UpdateDeliveryState(messageID, state string, at time.Time) error {
+	query := s.scoped().Model(&models.ChatMessage{}).Where("message_id = ?", messageID)
+
+	switch state {
+	case models.DeliveryDelivered:
+		return query.
+			Where("delivery_state = ?", models.DeliveryPending).
+			Updates(map[string]interface{}{"delivery_state": state, "delivered_at": at}).Error
+	case models.DeliveryRead:
+		return query.
+			Where("delivery_state <> ?", models.DeliveryRead).
+			Updates(map[string]interface{}{
+				"delivery_state": state,
+				"delivered_at":   gorm.Expr("COALESCE(delivered_at, ?)", at),
+				"read_at":        at,
+			}).Error
+	default:
+		return fmt.Errorf("unknown delivery state %q", state)
+	}
 }
 
-// PersistMessage sends message to persistence service via RPC
-func PersistMessage(msg models.Message) {
-	client, err := rpc.DialHTTP("tcp", "localhost:8081")
+// EditMessage replaces the text of a message on behalf of its sender
+func (s *ChatService) EditMessage(messageID, sender, text string, at time.Time) (*models.ChatMessage, error) {
+	record, err := s.ownedMessage(messageID, sender)
 	if err != nil {
-		log.Printf("Error connecting to persistence service: %v", err)
-		return
+		return nil, err
+	}
+
+	err = s.db.Model(record).Updates(map[string]interface{}{"text": text, "edited_at": at}).Error
+	if err != nil {
+		return nil, err
+	}
+	record.Text = text
+	record.EditedAt = &at
+	return record, nil
+}
+
+// DeleteMessage blanks a message on behalf of its sender, leaving a tombstone
+func (s *ChatService) DeleteMessage(messageID, sender string, at time.Time) (*models.ChatMessage, error) {
+	record, err := s.ownedMessage(messageID, sender)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.db.Model(record).Updates(map[string]interface{}{"text": "", "deleted_at": at}).Error
+	if err != nil {
+		return nil, err
+	}
+	record.Text = ""
+	record.DeletedAt = &at
+	return record, nil
+}
+
+// ownedMessage loads a live message and checks it belongs to sender
+func (s *ChatService) ownedMessage(messageID, sender string) (*models.ChatMessage, error) {
+	record, err := s.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if record.Sender != sender {
+		return nil, ErrNotSender
+	}
+	if record.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	return record, nil
+}
+
+// GetParent loads the message a reply refers to and checks it belongs to
+// the same conversation: the same room, or the same pair of users
+func (s *ChatService) GetParent(msg models.Message) (*models.ChatMessage, error) {
+	parent, err := s.GetMessage(msg.ParentID)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.RoomID != "" || parent.RoomID != "" {
+		if parent.RoomID != msg.RoomID {
+			return nil, ErrInvalidParent
+		}
+		return parent, nil
+	}
+
+	samePair := (parent.Sender == msg.Sender && parent.Receiver == msg.Receiver) ||
+		(parent.Sender == msg.Receiver && parent.Receiver == msg.Sender)
+	if !samePair {
+		return nil, ErrInvalidParent
+	}
+	return parent, nil
+}
+
+// GetThread returns a page of replies to a message, oldest first, and the total number of replies
+func (s *ChatService) GetThread(parentID string, limit, offset int) ([]models.ChatMessage, int64, error) {
+	if limit <= 0 {
+		limit = defaultHistoryLimit
 	}
-	defer client.Close()
 
-	msgJSON, _ := json.Marshal(msg)
-	persistMsg := models.PersistMessage{
-		Receiver: msg.Receiver,
-		Message:  string(msgJSON),
+	var total int64
+	query := s.scoped().Model(&models.ChatMessage{}).Where("parent_id = ?", parentID).Session(&gorm.Session{})
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
 	}
 
-	var result string
-	err = client.Call("PersistenceService.SaveMessage", persistMsg, &result)
+	var replies []models.ChatMessage
+	err := query.
+		Order("timestamp ASC").
+		Order("id ASC").
+		Limit(limit).
+		Offset(offset).
+		Find(&replies).Error
 	if err != nil {
-		log.Printf("Error persisting message: %v", err)
+		return nil, 0, err
 	}
+	return replies, total, nil
 }
@@ -0,0 +1,299 @@
+package services
+
+import (
+	"chatsystem/internal/models"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// newTestDB opens a private in-memory database with the chat schema
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	err = db.AutoMigrate(models.All()...)
+	if err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// saveAll stores msgs in order, failing the test on the first error
+func saveAll(t *testing.T, chat *ChatService, msgs ...models.Message) {
+	t.Helper()
+	for _, msg := range msgs {
+		if err := chat.SaveMessage(msg); err != nil {
+			t.Fatalf("saving %s: %v", msg.ID, err)
+		}
+	}
+}
+
+func direct(id, sender, receiver, text string, at time.Time) models.Message {
+	return models.Message{
+		ID:        id,
+		Sender:    sender,
+		Receiver:  receiver,
+		Text:      text,
+		Type:      "chat",
+		Timestamp: at,
+	}
+}
+
+func messageIDs(records []models.ChatMessage) []string {
+	ids := make([]string, 0, len(records))
+	for _, record := range records {
+		ids = append(ids, record.MessageID)
+	}
+	return ids
+}
+
+func equalIDs(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestSaveMessageRoundTrip(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	at := time.Date(2024, 5, 1, 12, 30, 15, 0, time.UTC)
+
+	sent := models.Message{
+		ID:        "m1",
+		Sender:    "alice",
+		Receiver:  "bob",
+		Text:      "hello bob",
+		Type:      "chat",
+		Timestamp: at,
+		ParentID:  "m0",
+	}
+	saveAll(t, chat, sent)
+
+	record, err := chat.GetMessage("m1")
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	got := record.ToMessage()
+	if got.ID != sent.ID || got.Sender != sent.Sender || got.Receiver != sent.Receiver ||
+		got.Text != sent.Text || got.Type != sent.Type || got.ParentID != sent.ParentID {
+		t.Errorf("round trip changed the message: got %+v, want %+v", got, sent)
+	}
+	if !got.Timestamp.Equal(at) {
+		t.Errorf("timestamp = %v, want %v", got.Timestamp, at)
+	}
+	if got.Status != models.DeliveryPending {
+		t.Errorf("status = %q, want %q", got.Status, models.DeliveryPending)
+	}
+	if got.Tenant != models.DefaultTenant {
+		t.Errorf("tenant = %q, want %q", got.Tenant, models.DefaultTenant)
+	}
+}
+
+func TestSaveMessageAssignsIDAndTimestamp(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	saveAll(t, chat, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat"})
+
+	records, err := chat.GetMessages(models.QueryMessage{UserID: "bob"})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(records) != 1 {
+		t.Fatalf("got %d messages, want 1", len(records))
+	}
+	if len(records[0].MessageID) != 32 {
+		t.Errorf("message ID %q was not generated", records[0].MessageID)
+	}
+	if records[0].Timestamp.IsZero() {
+		t.Error("timestamp was not set")
+	}
+}
+
+func TestGetMessagesOrdering(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+	// Saved out of order, and two share a timestamp
+	saveAll(t, chat,
+		direct("m3", "bob", "alice", "third", base.Add(2*time.Minute)),
+		direct("m1", "alice", "bob", "first", base),
+		direct("m2a", "alice", "bob", "second", base.Add(time.Minute)),
+		direct("m2b", "bob", "alice", "second too", base.Add(time.Minute)),
+	)
+
+	records, err := chat.GetMessages(models.QueryMessage{UserID: "alice"})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	// Oldest first, ties broken by insertion order
+	want := []string{"m1", "m2a", "m2b", "m3"}
+	if got := messageIDs(records); !equalIDs(got, want) {
+		t.Errorf("order = %v, want %v", got, want)
+	}
+}
+
+func TestGetMessagesLimitKeepsLatest(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 5; i++ {
+		saveAll(t, chat, direct(fmt.Sprintf("m%d", i), "alice", "bob", "hi", base.Add(time.Duration(i)*time.Minute)))
+	}
+
+	records, err := chat.GetMessages(models.QueryMessage{UserID: "bob", Limit: 2})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	want := []string{"m3", "m4"}
+	if got := messageIDs(records); !equalIDs(got, want) {
+		t.Errorf("limited history = %v, want %v", got, want)
+	}
+}
+
+func TestGetMessagesOnlyReturnsUsersConversations(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	saveAll(t, chat,
+		direct("m1", "alice", "bob", "for bob", at),
+		direct("m2", "carol", "dave", "not for bob", at),
+	)
+
+	records, err := chat.GetMessages(models.QueryMessage{UserID: "bob"})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if got := messageIDs(records); !equalIDs(got, []string{"m1"}) {
+		t.Errorf("history = %v, want [m1]", got)
+	}
+}
+
+func TestGetMessagesEmptyHistory(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+
+	records, err := chat.GetMessages(models.QueryMessage{UserID: "nobody", Limit: 10})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(records) != 0 {
+		t.Errorf("got %d messages for a user with no history", len(records))
+	}
+}
+
+func TestGetMessagesIsolatesTenants(t *testing.T) {
+	db := newTestDB(t)
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	saveAll(t, NewChatService(db).ForTenant("acme"), direct("m1", "alice", "bob", "acme", at))
+	saveAll(t, NewChatService(db).ForTenant("globex"), direct("m2", "alice", "bob", "globex", at))
+
+	records, err := NewChatService(db).ForTenant("globex").GetMessages(models.QueryMessage{UserID: "bob"})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if got := messageIDs(records); !equalIDs(got, []string{"m2"}) {
+		t.Errorf("globex history = %v, want [m2]", got)
+	}
+	if _, err := NewChatService(db).ForTenant("globex").GetMessage("m1"); err != ErrMessageNotFound {
+		t.Errorf("GetMessage across tenants = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestGetThreadLimitOffset(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	base := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	saveAll(t, chat, direct("parent", "alice", "bob", "question", base))
+	for i := 1; i <= 5; i++ {
+		reply := direct(fmt.Sprintf("r%d", i), "bob", "alice", "answer", base.Add(time.Duration(i)*time.Minute))
+		reply.ParentID = "parent"
+		saveAll(t, chat, reply)
+	}
+
+	tests := []struct {
+		limit, offset int
+		want          []string
+	}{
+		{limit: 2, offset: 0, want: []string{"r1", "r2"}},
+		{limit: 2, offset: 2, want: []string{"r3", "r4"}},
+		{limit: 2, offset: 4, want: []string{"r5"}},
+		{limit: 2, offset: 6, want: []string{}},
+	}
+	for _, tt := range tests {
+		records, total, err := chat.GetThread("parent", tt.limit, tt.offset)
+		if err != nil {
+			t.Fatalf("GetThread(%d, %d): %v", tt.limit, tt.offset, err)
+		}
+		if total != 5 {
+			t.Errorf("GetThread(%d, %d) total = %d, want 5", tt.limit, tt.offset, total)
+		}
+		if got := messageIDs(records); !equalIDs(got, tt.want) {
+			t.Errorf("GetThread(%d, %d) = %v, want %v", tt.limit, tt.offset, got, tt.want)
+		}
+	}
+}
+
+func TestGetMessageNotFound(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	if _, err := chat.GetMessage("missing"); err != ErrMessageNotFound {
+		t.Errorf("GetMessage = %v, want ErrMessageNotFound", err)
+	}
+}
+
+func TestGetMessagesRoomHistoryStartsAtJoin(t *testing.T) {
+	db := newTestDB(t)
+	chat := NewChatService(db)
+	rooms := NewRoomService(db)
+
+	room, err := rooms.CreateRoom("team", "alice", true)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	before := models.Message{ID: "before", Sender: "alice", RoomID: room.ID, Text: "before bob", Type: "room", Timestamp: time.Now().Add(-time.Minute)}
+	saveAll(t, chat, before)
+
+	if err := rooms.JoinRoom(room.ID, "bob"); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	after := models.Message{ID: "after", Sender: "alice", RoomID: room.ID, Text: "welcome bob", Type: "room", Timestamp: time.Now().Add(time.Second)}
+	saveAll(t, chat, after)
+
+	records, err := chat.GetMessages(models.QueryMessage{UserID: "bob"})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if got := messageIDs(records); !equalIDs(got, []string{"after"}) {
+		t.Errorf("bob's history = %v, want [after]", got)
+	}
+
+	records, err = chat.GetMessages(models.QueryMessage{UserID: "alice"})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if got := messageIDs(records); !equalIDs(got, []string{"before", "after"}) {
+		t.Errorf("alice's history = %v, want [before after]", got)
+	}
+
+	for id, want := range map[string]bool{"before": false, "after": true} {
+		record, err := chat.GetMessage(id)
+		if err != nil {
+			t.Fatalf("GetMessage(%s): %v", id, err)
+		}
+		if got, err := chat.IsParticipant(record, "bob"); err != nil || got != want {
+			t.Errorf("IsParticipant(%s, bob) = %v, %v, want %v", id, got, err, want)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package services
+
+import "unicode/utf8"
+
+// pictographic approximates Unicode's Extended_Pictographic property, the
+// code points that can start an emoji
+var pictographic = [][2]rune{
+	{0x00A9, 0x00A9}, {0x00AE, 0x00AE}, {0x203C, 0x203C}, {0x2049, 0x2049},
+	{0x2122, 0x2122}, {0x2139, 0x2139}, {0x2194, 0x2199}, {0x21A9, 0x21AA},
+	{0x231A, 0x231B}, {0x2328, 0x2328}, {0x23CF, 0x23CF}, {0x23E9, 0x23F3},
+	{0x23F8, 0x23FA}, {0x24C2, 0x24C2}, {0x25AA, 0x25AB}, {0x25B6, 0x25B6},
+	{0x25C0, 0x25C0}, {0x25FB, 0x25FE}, {0x2600, 0x27BF}, {0x2934, 0x2935},
+	{0x2B05, 0x2B07}, {0x2B1B, 0x2B1C}, {0x2B50, 0x2B50}, {0x2B55, 0x2B55},
+	{0x3030, 0x3030}, {0x303D, 0x303D}, {0x3297, 0x3297}, {0x3299, 0x3299},
+	{0x1F000, 0x1F1E5}, {0x1F200, 0x1F3FA}, {0x1F400, 0x1FAFF},
+}
+
+const (
+	zeroWidthJoiner = 0x200D
+	textStyle       = 0xFE0E
+	emojiStyle      = 0xFE0F
+	enclosingKeycap = 0x20E3
+	tagCancel       = 0xE007F
+)
+
+// validEmoji reports whether s is a single emoji: one pictograph with its
+// optional presentation selector, skin tone and tags, a flag, a keycap, or a
+// ZWJ sequence joining several of them
+func validEmoji(s string) bool {
+	if s == "" || len(s) > maxEmojiLength || !utf8.ValidString(s) {
+		return false
+	}
+
+	runes := []rune(s)
+	i := 0
+	for {
+		n := emojiElement(runes[i:])
+		if n == 0 {
+			return false
+		}
+		i += n
+		if i == len(runes) {
+			return true
+		}
+		if runes[i] != zeroWidthJoiner {
+			return false
+		}
+		i++
+		if i == len(runes) {
+			return false
+		}
+	}
+}
+
+// emojiElement returns how many runes at the start of r form one emoji,
+// not counting ZWJ sequences, or 0 if r doesn't start with one
+func emojiElement(r []rune) int {
+	switch {
+	case isRegionalIndicator(r[0]):
+		// Flags are a pair of regional indicators
+		if len(r) >= 2 && isRegionalIndicator(r[1]) {
+			return 2
+		}
+		return 0
+
+	case r[0] == '#' || r[0] == '*' || (r[0] >= '0' && r[0] <= '9'):
+		i := 1
+		if i < len(r) && r[i] == emojiStyle {
+			i++
+		}
+		if i < len(r) && r[i] == enclosingKeycap {
+			return i + 1
+		}
+		return 0
+
+	case isPictographic(r[0]):
+		i := 1
+		if i < len(r) && (r[i] == emojiStyle || r[i] == textStyle) {
+			i++
+		}
+		if i < len(r) && isSkinTone(r[i]) {
+			i++
+		}
+		// Subdivision flags spell their region in tag characters
+		if i < len(r) && isTag(r[i]) {
+			for i < len(r) && isTag(r[i]) {
+				i++
+			}
+			if i >= len(r) || r[i] != tagCancel {
+				return 0
+			}
+			i++
+		}
+		return i
+	}
+	return 0
+}
+
+func isPictographic(r rune) bool {
+	for _, span := range pictographic {
+		if r >= span[0] && r <= span[1] {
+			return true
+		}
+	}
+	return false
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+func isSkinTone(r rune) bool {
+	return r >= 0x1F3FB && r <= 0x1F3FF
+}
+
+func isTag(r rune) bool {
+	return r >= 0xE0020 && r <= 0xE007E
+}
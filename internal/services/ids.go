@@ -0,0 +1,18 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// NewID returns a 32 character identifier that sorts by creation time:
+// 12 hex digits of Unix milliseconds followed by 80 random bits.
+func NewID() string {
+	var random [10]byte
+	if _, err := rand.Read(random[:]); err != nil {
+		panic(fmt.Sprintf("failed to generate id: %v", err))
+	}
+	return fmt.Sprintf("%012x%s", time.Now().UnixMilli(), hex.EncodeToString(random[:]))
+}
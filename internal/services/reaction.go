@@ -0,0 +1,121 @@
+package services
+
+import (
+	"chatsystem/internal/models"
+	"errors"
+
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrNotParticipant = errors.New("not a participant in this conversation")
+	ErrInvalidEmoji   = errors.New("invalid emoji")
+)
+
+// maxEmojiLength bounds the stored reaction, long enough for ZWJ sequences
+const maxEmojiLength = 32
+
+// AddReaction records a user's reaction to a message they can see
+func (s *ChatService) AddReaction(messageID, userID, emoji string) (*models.ChatMessage, error) {
+	record, err := s.reactableMessage(messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	reaction := models.MessageReaction{MessageID: messageID, TenantID: s.tenant, UserID: userID, Emoji: emoji}
+	if err := s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&reaction).Error; err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// RemoveReaction withdraws a user's reaction to a message
+func (s *ChatService) RemoveReaction(messageID, userID, emoji string) (*models.ChatMessage, error) {
+	record, err := s.reactableMessage(messageID, userID, emoji)
+	if err != nil {
+		return nil, err
+	}
+
+	err = s.scoped().
+		Where("message_id = ? AND user_id = ? AND emoji = ?", messageID, userID, emoji).
+		Delete(&models.MessageReaction{}).Error
+	if err != nil {
+		return nil, err
+	}
+	return record, nil
+}
+
+// GetReactions aggregates the reactions to each of the tenant's given messages
+func (s *ChatService) GetReactions(messageIDs []string) (map[string][]models.ReactionSummary, error) {
+	summaries := make(map[string][]models.ReactionSummary)
+	if len(messageIDs) == 0 {
+		return summaries, nil
+	}
+
+	var reactions []models.MessageReaction
+	err := s.scoped().
+		Where("message_id IN ?", messageIDs).
+		Order("created_at").
+		Find(&reactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, reaction := range reactions {
+		list := summaries[reaction.MessageID]
+		found := false
+		for i := range list {
+			if list[i].Emoji == reaction.Emoji {
+				list[i].Count++
+				list[i].Users = append(list[i].Users, reaction.UserID)
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, models.ReactionSummary{
+				Emoji: reaction.Emoji,
+				Count: 1,
+				Users: []string{reaction.UserID},
+			})
+		}
+		summaries[reaction.MessageID] = list
+	}
+	return summaries, nil
+}
+
+// reactableMessage loads a live message and checks userID takes part in its conversation
+func (s *ChatService) reactableMessage(messageID, userID, emoji string) (*models.ChatMessage, error) {
+	if !validEmoji(emoji) {
+		return nil, ErrInvalidEmoji
+	}
+
+	record, err := s.GetMessage(messageID)
+	if err != nil {
+		return nil, err
+	}
+	if record.DeletedAt != nil {
+		return nil, ErrMessageDeleted
+	}
+	if ok, err := s.IsParticipant(record, userID); err != nil || !ok {
+		if err == nil {
+			err = ErrNotParticipant
+		}
+		return nil, err
+	}
+	return record, nil
+}
+
+// IsParticipant reports whether userID is the sender or receiver of a
+// message, or joined the room it was sent to before it was sent
+func (s *ChatService) IsParticipant(record *models.ChatMessage, userID string) (bool, error) {
+	if record.RoomID == "" || record.Sender == userID {
+		return record.Sender == userID || record.Receiver == userID, nil
+	}
+
+	var count int64
+	err := s.scoped().Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ? AND joined_at <= ?", record.RoomID, userID, record.Timestamp).
+		Count(&count).Error
+	return count > 0, err
+}
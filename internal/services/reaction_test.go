@@ -0,0 +1,68 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestValidEmoji(t *testing.T) {
+	tests := []struct {
+		emoji string
+		want  bool
+	}{
+		{"👍", true},
+		{"👍🏽", true},
+		{"❤️", true},
+		{"☺", true},
+		{"🇳🇬", true},
+		{"1️⃣", true},
+		{"👩‍👩‍👧", true},
+		{"🏴󠁧󠁢󠁳󠁣󠁴󠁿", true},
+		{"", false},
+		{"a", false},
+		{"1", false},
+		{"👍👍", false},
+		{"👍a", false},
+		{"🇳", false},
+		{"‍👍", false},
+		{"👍‍", false},
+		{"🏴󠁧󠁢", false},
+		{"<b>", false},
+		{"😀😀😀😀😀😀😀😀😀", false},
+	}
+	for _, tt := range tests {
+		if got := validEmoji(tt.emoji); got != tt.want {
+			t.Errorf("validEmoji(%q) = %v, want %v", tt.emoji, got, tt.want)
+		}
+	}
+}
+
+func TestAddReactionRefusesText(t *testing.T) {
+	chat := NewChatService(newTestDB(t))
+	saveAll(t, chat, direct("m1", "alice", "bob", "hi", time.Now()))
+
+	if _, err := chat.AddReaction("m1", "bob", "lol"); !errors.Is(err, ErrInvalidEmoji) {
+		t.Errorf("AddReaction = %v, want ErrInvalidEmoji", err)
+	}
+}
+
+func TestGetReactionsIsolatesTenants(t *testing.T) {
+	db := newTestDB(t)
+	acme := NewChatService(db).ForTenant("acme")
+	saveAll(t, acme, direct("m1", "alice", "bob", "hi", time.Now()))
+	if _, err := acme.AddReaction("m1", "bob", "👍"); err != nil {
+		t.Fatalf("AddReaction: %v", err)
+	}
+
+	reactions, err := NewChatService(db).ForTenant("globex").GetReactions([]string{"m1"})
+	if err != nil {
+		t.Fatalf("GetReactions: %v", err)
+	}
+	if len(reactions) != 0 {
+		t.Errorf("globex sees acme's reactions: %v", reactions)
+	}
+	if reactions, _ := acme.GetReactions([]string{"m1"}); len(reactions["m1"]) != 1 {
+		t.Errorf("acme reactions = %v", reactions)
+	}
+}
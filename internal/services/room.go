@@ -0,0 +1,152 @@
+package services
+
+import (
+	"chatsystem/internal/models"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrRoomNotFound = errors.New("room not found")
+	ErrRoomClosed   = errors.New("room is invite only")
+	ErrNotRoomOwner = errors.New("only the room's creator can invite members")
+)
+
+type RoomService struct {
+	db     *gorm.DB
+	tenant string
+}
+
+func NewRoomService(db *gorm.DB) *RoomService {
+	return &RoomService{
+		db:     db,
+		tenant: models.DefaultTenant,
+	}
+}
+
+// ForTenant returns a RoomService that only sees tenant's rooms
+func (s *RoomService) ForTenant(tenant string) *RoomService {
+	return &RoomService{
+		db:     s.db,
+		tenant: tenant,
+	}
+}
+
+// scoped starts a query limited to the service's tenant
+func (s *RoomService) scoped() *gorm.DB {
+	return s.db.Where("tenant_id = ?", s.tenant)
+}
+
+// CreateRoom creates a room and makes its creator the first member. Anyone
+// in the tenant may join an open room, others only take invited members.
+func (s *RoomService) CreateRoom(name, creator string, open bool) (*models.Room, error) {
+	room := models.Room{
+		ID:        NewID(),
+		TenantID:  s.tenant,
+		Name:      name,
+		CreatedBy: creator,
+		Open:      open,
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&room).Error; err != nil {
+			return err
+		}
+		return tx.Create(&models.RoomMember{
+			RoomID:   room.ID,
+			UserID:   creator,
+			TenantID: s.tenant,
+			JoinedAt: time.Now(),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// GetRoom loads one of the tenant's rooms
+func (s *RoomService) GetRoom(roomID string) (*models.Room, error) {
+	var room models.Room
+	err := s.scoped().Where("id = ?", roomID).First(&room).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, ErrRoomNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// JoinRoom adds a user to an open room, joining twice is not an error
+func (s *RoomService) JoinRoom(roomID, userID string) error {
+	room, err := s.GetRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if !room.Open {
+		if member, err := s.IsMember(roomID, userID); err != nil || member {
+			return err
+		}
+		return ErrRoomClosed
+	}
+	return s.addMembers(roomID, []string{userID})
+}
+
+// InviteMembers adds users to a room on behalf of its creator. Users who
+// are already members are left as they are.
+func (s *RoomService) InviteMembers(roomID, inviter string, userIDs []string) error {
+	room, err := s.GetRoom(roomID)
+	if err != nil {
+		return err
+	}
+	if room.CreatedBy != inviter {
+		return ErrNotRoomOwner
+	}
+	return s.addMembers(roomID, userIDs)
+}
+
+func (s *RoomService) addMembers(roomID string, userIDs []string) error {
+	if len(userIDs) == 0 {
+		return nil
+	}
+	now := time.Now()
+	members := make([]models.RoomMember, 0, len(userIDs))
+	for _, userID := range userIDs {
+		members = append(members, models.RoomMember{
+			RoomID:   roomID,
+			UserID:   userID,
+			TenantID: s.tenant,
+			JoinedAt: now,
+		})
+	}
+	// Keep existing members' join times, so their history doesn't shrink
+	return s.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&members).Error
+}
+
+// LeaveRoom removes a user from a room
+func (s *RoomService) LeaveRoom(roomID, userID string) error {
+	return s.scoped().Where("room_id = ? AND user_id = ?", roomID, userID).Delete(&models.RoomMember{}).Error
+}
+
+// GetMembers lists the user IDs belonging to a room
+func (s *RoomService) GetMembers(roomID string) ([]string, error) {
+	var members []string
+	err := s.scoped().Model(&models.RoomMember{}).
+		Where("room_id = ?", roomID).
+		Order("joined_at").
+		Pluck("user_id", &members).Error
+	return members, err
+}
+
+// IsMember reports whether a user belongs to a room
+func (s *RoomService) IsMember(roomID, userID string) (bool, error) {
+	var count int64
+	err := s.scoped().Model(&models.RoomMember{}).
+		Where("room_id = ? AND user_id = ?", roomID, userID).
+		Count(&count).Error
+	return count > 0, err
+}
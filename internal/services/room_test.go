@@ -0,0 +1,65 @@
+package services
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestJoinRoomOnlyOpensOpenRooms(t *testing.T) {
+	rooms := NewRoomService(newTestDB(t))
+
+	open, err := rooms.CreateRoom("lobby", "alice", true)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	closed, err := rooms.CreateRoom("private", "alice", false)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	if err := rooms.JoinRoom(open.ID, "bob"); err != nil {
+		t.Errorf("joining an open room: %v", err)
+	}
+	if err := rooms.JoinRoom(closed.ID, "bob"); !errors.Is(err, ErrRoomClosed) {
+		t.Errorf("joining a closed room = %v, want ErrRoomClosed", err)
+	}
+	if err := rooms.JoinRoom(closed.ID, "alice"); err != nil {
+		t.Errorf("rejoining as a member: %v", err)
+	}
+	if err := rooms.JoinRoom("missing", "bob"); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("joining a missing room = %v, want ErrRoomNotFound", err)
+	}
+	if member, _ := rooms.IsMember(closed.ID, "bob"); member {
+		t.Error("bob got into the closed room")
+	}
+}
+
+func TestInviteMembers(t *testing.T) {
+	rooms := NewRoomService(newTestDB(t))
+	room, err := rooms.CreateRoom("private", "alice", false)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+
+	if err := rooms.InviteMembers(room.ID, "alice", []string{"bob", "carol"}); err != nil {
+		t.Fatalf("InviteMembers: %v", err)
+	}
+	if err := rooms.InviteMembers(room.ID, "bob", []string{"mallory"}); !errors.Is(err, ErrNotRoomOwner) {
+		t.Errorf("invite from a member = %v, want ErrNotRoomOwner", err)
+	}
+	// Inviting an existing member again is harmless
+	if err := rooms.InviteMembers(room.ID, "alice", []string{"bob"}); err != nil {
+		t.Errorf("re-inviting: %v", err)
+	}
+
+	members, err := rooms.GetMembers(room.ID)
+	if err != nil {
+		t.Fatalf("GetMembers: %v", err)
+	}
+	if len(members) != 3 {
+		t.Errorf("members = %v, want alice, bob and carol", members)
+	}
+	if _, err := rooms.ForTenant("globex").GetRoom(room.ID); !errors.Is(err, ErrRoomNotFound) {
+		t.Errorf("GetRoom from another tenant = %v, want ErrRoomNotFound", err)
+	}
+}
@@ -0,0 +1,96 @@
+package services
+
+import (
+	"chatsystem/internal/models"
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// tenantCacheTTL is how long tenant settings are cached, and so how long a
+// change takes to reach every node
+const tenantCacheTTL = 30 * time.Second
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrInvalidTenant  = errors.New("invalid tenant ID")
+)
+
+type cachedTenant struct {
+	tenant    *models.Tenant
+	fetchedAt time.Time
+}
+
+type TenantService struct {
+	db    *gorm.DB
+	mu    sync.RWMutex
+	cache map[string]cachedTenant
+}
+
+func NewTenantService(db *gorm.DB) *TenantService {
+	return &TenantService{
+		db:    db,
+		cache: make(map[string]cachedTenant),
+	}
+}
+
+// GetTenant returns a tenant's settings, cached briefly because they are read
+// on every connection and message. The default tenant always exists, with
+// server-wide limits unless it has been configured.
+func (s *TenantService) GetTenant(id string) (*models.Tenant, error) {
+	s.mu.RLock()
+	cached, ok := s.cache[id]
+	s.mu.RUnlock()
+	if ok && time.Since(cached.fetchedAt) < tenantCacheTTL {
+		return cached.tenant, nil
+	}
+
+	var tenant models.Tenant
+	err := s.db.Where("id = ?", id).First(&tenant).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound) && id == models.DefaultTenant:
+		tenant = models.Tenant{ID: models.DefaultTenant, Name: "Default"}
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, ErrTenantNotFound
+	case err != nil:
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.cache[id] = cachedTenant{tenant: &tenant, fetchedAt: time.Now()}
+	s.mu.Unlock()
+	return &tenant, nil
+}
+
+// ListTenants returns every configured tenant
+func (s *TenantService) ListTenants() ([]models.Tenant, error) {
+	var tenants []models.Tenant
+	err := s.db.Order("id").Find(&tenants).Error
+	return tenants, err
+}
+
+// SaveTenant creates a tenant or replaces its settings
+func (s *TenantService) SaveTenant(tenant models.Tenant) (*models.Tenant, error) {
+	if !models.ValidTenantID(tenant.ID) {
+		return nil, ErrInvalidTenant
+	}
+
+	err := s.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.AssignmentColumns([]string{
+			"name", "disabled", "max_connections", "messages_per_minute",
+			"history_limit", "max_upload_size", "admin_users", "updated_at",
+		}),
+	}).Create(&tenant).Error
+	if err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	delete(s.cache, tenant.ID)
+	s.mu.Unlock()
+	return s.GetTenant(tenant.ID)
+}
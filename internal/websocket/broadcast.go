@@ -0,0 +1,38 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"time"
+)
+
+// Broadcast delivers an announcement to every client of a tenant on every node
+func (s *Hub) Broadcast(tenant, sender, text string) models.Message {
+	msg := models.Message{
+		Text:      text,
+		Sender:    sender,
+		Tenant:    tenant,
+		Type:      "broadcast",
+		Timestamp: time.Now(),
+	}
+	s.broadcastLocal(msg)
+	s.publishBroadcast(msg)
+	return msg
+}
+
+// broadcastLocal sends msg to every client of its tenant connected to this node
+func (s *Hub) broadcastLocal(msg models.Message) {
+	s.mutex.RLock()
+	clients := make([]*Client, 0, s.connections[msg.Tenant])
+	for _, devices := range s.clients {
+		for _, client := range devices {
+			if client.Tenant == msg.Tenant {
+				clients = append(clients, client)
+			}
+		}
+	}
+	s.mutex.RUnlock()
+
+	for _, client := range clients {
+		client.Send(msg)
+	}
+}
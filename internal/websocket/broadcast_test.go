@@ -0,0 +1,50 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"testing"
+)
+
+func TestBroadcastReachesTenantOnEveryNode(t *testing.T) {
+	nodes := newTestCluster(t, "node-a", "node-b")
+	alice := connect(nodes[0], "alice", "phone")
+	bob := connect(nodes[1], "bob", "laptop")
+	outsider := connectIn(nodes[0], "acme", "carol", "tablet")
+
+	sent := nodes[0].Broadcast(models.DefaultTenant, "server", "maintenance at noon")
+
+	for _, client := range []*Client{alice, bob} {
+		got := next(t, client, "broadcast")
+		if got.Text != sent.Text || got.Sender != "server" {
+			t.Errorf("%s got %+v", client.ID, got)
+		}
+		// The origin node must not deliver its own broadcast again from pub/sub
+		none(t, client, "broadcast")
+	}
+	none(t, outsider, "broadcast")
+}
+
+func TestOnlyAdminsMayBroadcast(t *testing.T) {
+	withConfig(t, &config.AppConfig.AdminUsers, []string{"root"})
+	hub := newTestHub(t)
+	if _, err := hub.tenantService.SaveTenant(models.Tenant{ID: "acme", Name: "Acme", AdminUsers: models.StringList{"ops"}}); err != nil {
+		t.Fatalf("SaveTenant: %v", err)
+	}
+
+	tests := []struct {
+		tenant, user string
+		want         bool
+	}{
+		{models.DefaultTenant, "root", true},
+		{models.DefaultTenant, "alice", false},
+		{"acme", "ops", true},
+		{"acme", "root", false},
+		{models.DefaultTenant, "ops", false},
+	}
+	for _, tt := range tests {
+		if got := hub.IsAdmin(tt.tenant, tt.user); got != tt.want {
+			t.Errorf("IsAdmin(%s, %s) = %v, want %v", tt.tenant, tt.user, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"errors"
+	"log"
+	"time"
+)
+
+// RequestChange queues an edit, delete or reaction frame from client.
+// Changes to a message go through the same persist queue, so they are
+// applied in the order they were sent.
+func (s *Hub) RequestChange(client *Client, msg models.Message) {
+	if client.ID == "" {
+		return
+	}
+	if msg.MessageID == "" {
+		s.sendMessageError(client, "", "message_id is required")
+		return
+	}
+	if msg.Type == "edit" && msg.Text == "" {
+		s.sendMessageError(client, msg.MessageID, "text is required, use delete to remove a message")
+		return
+	}
+	if (msg.Type == "reaction_add" || msg.Type == "reaction_remove") && msg.Emoji == "" {
+		s.sendMessageError(client, msg.MessageID, "emoji is required")
+		return
+	}
+
+	msg.Sender = client.ID
+	msg.Tenant = client.Tenant
+	msg.DeviceID = client.DeviceID
+	s.SendToPersist(msg)
+}
+
+// applyChange stores an edit or delete and propagates it to the live
+// connections of everyone in the conversation. It runs on a persist worker.
+func (s *Hub) applyChange(msg models.Message) {
+	var (
+		record *models.ChatMessage
+		err    error
+		event  = models.Message{
+			Sender:    msg.Sender,
+			MessageID: msg.MessageID,
+			Timestamp: time.Now(),
+		}
+	)
+
+	chat := s.chatService.ForTenant(msg.Tenant)
+	switch msg.Type {
+	case "edit":
+		record, err = chat.EditMessage(msg.MessageID, msg.Sender, msg.Text, msg.Timestamp)
+		event.Type = "message_edited"
+	case "delete":
+		record, err = chat.DeleteMessage(msg.MessageID, msg.Sender, msg.Timestamp)
+		event.Type = "message_deleted"
+	}
+
+	if err != nil {
+		s.reportChangeError(msg, err)
+		return
+	}
+
+	changed := record.ToMessage()
+	event.Text = changed.Text
+	event.EditedAt = changed.EditedAt
+	event.Deleted = changed.Deleted
+	event.Receiver = record.Receiver
+	event.RoomID = record.RoomID
+	s.deliverToConversation(record, event)
+}
+
+// applyReaction stores a reaction change and pushes the message's updated
+// reaction counts to the conversation. It runs on a persist worker.
+func (s *Hub) applyReaction(msg models.Message) {
+	var (
+		record *models.ChatMessage
+		err    error
+		chat   = s.chatService.ForTenant(msg.Tenant)
+	)
+	if msg.Type == "reaction_add" {
+		record, err = chat.AddReaction(msg.MessageID, msg.Sender, msg.Emoji)
+	} else {
+		record, err = chat.RemoveReaction(msg.MessageID, msg.Sender, msg.Emoji)
+	}
+	if err != nil {
+		s.reportChangeError(msg, err)
+		return
+	}
+
+	reactions, err := chat.GetReactions([]string{msg.MessageID})
+	if err != nil {
+		log.Printf("Error loading reactions for %s: %v", msg.MessageID, err)
+		return
+	}
+
+	s.deliverToConversation(record, models.Message{
+		Sender:    msg.Sender,
+		Receiver:  record.Receiver,
+		RoomID:    record.RoomID,
+		Type:      msg.Type,
+		MessageID: msg.MessageID,
+		Emoji:     msg.Emoji,
+		Reactions: reactions[msg.MessageID],
+		Timestamp: time.Now(),
+	})
+}
+
+// reportChangeError tells the device that requested a change why it failed
+func (s *Hub) reportChangeError(msg models.Message, err error) {
+	text := "Could not change message"
+	switch {
+	case errors.Is(err, services.ErrMessageNotFound):
+		text = "Message not found"
+	case errors.Is(err, services.ErrNotSender):
+		text = "Only the sender can change a message"
+	case errors.Is(err, services.ErrMessageDeleted):
+		text = "Message has been deleted"
+	case errors.Is(err, services.ErrNotParticipant):
+		text = "Not a participant in this conversation"
+	case errors.Is(err, services.ErrInvalidEmoji):
+		text = "Invalid emoji"
+	default:
+		log.Printf("Error applying %s to %s: %v", msg.Type, msg.MessageID, err)
+	}
+	if client, exists := s.GetClient(msg.Tenant, msg.Sender, msg.DeviceID); exists {
+		s.sendMessageError(client, msg.MessageID, text)
+	}
+}
+
+// deliverToConversation sends an event about a stored message to the live
+// connections of its sender and receiver, or of its room's members
+func (s *Hub) deliverToConversation(record *models.ChatMessage, event models.Message) {
+	tenant := record.TenantID
+	if record.RoomID != "" {
+		members, err := s.roomService.ForTenant(tenant).GetMembers(record.RoomID)
+		if err != nil {
+			log.Printf("Error loading members of room %s: %v", record.RoomID, err)
+			return
+		}
+		for _, member := range members {
+			s.deliverToUser(tenant, member, event, "", false)
+		}
+		return
+	}
+
+	s.deliverToUser(tenant, record.Sender, event, "", false)
+	if record.Receiver != record.Sender {
+		s.deliverToUser(tenant, record.Receiver, event, "", false)
+	}
+}
+
+func (s *Hub) sendMessageError(client *Client, messageID, text string) {
+	client.Send(models.Message{
+		Text:      text,
+		Sender:    "server",
+		Receiver:  client.ID,
+		Type:      "message_error",
+		MessageID: messageID,
+		Timestamp: time.Now(),
+	})
+}
@@ -0,0 +1,81 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"testing"
+)
+
+// sendChat submits a message from client to receiver and returns it as delivered
+func sendChat(t *testing.T, hub *Hub, client *Client, receiver *Client, text string) models.Message {
+	t.Helper()
+	hub.Submit(client, models.Message{Sender: client.ID, Receiver: receiver.ID, Text: text, Type: "chat", DeviceID: client.DeviceID})
+	return next(t, receiver, "chat")
+}
+
+func TestEditReachesConversation(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	msg := sendChat(t, hub, alice, bob, "helo")
+
+	hub.RequestChange(alice, models.Message{Type: "edit", MessageID: msg.ID, Text: "hello"})
+	for _, client := range []*Client{alice, bob} {
+		got := next(t, client, "message_edited")
+		if got.MessageID != msg.ID || got.Text != "hello" || got.EditedAt == nil || got.Sender != "alice" {
+			t.Errorf("%s got %+v", client.ID, got)
+		}
+	}
+
+	record, err := hub.chatService.GetMessage(msg.ID)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if record.Text != "hello" || record.EditedAt == nil {
+		t.Errorf("stored %q edited at %v", record.Text, record.EditedAt)
+	}
+}
+
+func TestOnlySenderCanChangeMessage(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	msg := sendChat(t, hub, alice, bob, "hi")
+
+	for _, change := range []string{"edit", "delete"} {
+		hub.RequestChange(bob, models.Message{Type: change, MessageID: msg.ID, Text: "mine now"})
+		if got := next(t, bob, "message_error"); got.Text != "Only the sender can change a message" {
+			t.Errorf("%s by receiver: error = %q", change, got.Text)
+		}
+	}
+	hub.RequestChange(alice, models.Message{Type: "edit", MessageID: msg.ID})
+	next(t, alice, "message_error")
+	none(t, bob, "message_edited")
+}
+
+func TestDeleteLeavesTombstone(t *testing.T) {
+	hub := newTestHub(t)
+	go hub.ProcessHistoryRequests()
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	msg := sendChat(t, hub, alice, bob, "oops")
+
+	hub.RequestChange(alice, models.Message{Type: "delete", MessageID: msg.ID})
+	for _, client := range []*Client{alice, bob} {
+		got := next(t, client, "message_deleted")
+		if got.MessageID != msg.ID || !got.Deleted || got.Text != "" {
+			t.Errorf("%s got %+v", client.ID, got)
+		}
+	}
+
+	hub.RequestChange(alice, models.Message{Type: "edit", MessageID: msg.ID, Text: "un-oops"})
+	if got := next(t, alice, "message_error"); got.Text != "Message has been deleted" {
+		t.Errorf("edit after delete: error = %q", got.Text)
+	}
+
+	// History replays the tombstone without the text
+	tablet := connect(hub, "bob", "tablet")
+	hub.FetchHistoricalMessages(models.DefaultTenant, "bob", "tablet")
+	if got := next(t, tablet, "history"); got.ID != msg.ID || !got.Deleted || got.Text != "" {
+		t.Errorf("history = %+v, want a tombstone", got)
+	}
+}
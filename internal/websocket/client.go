@@ -1,19 +1,422 @@
 package websocket
 
 import (
+	"chatsystem/internal/config"
 	"chatsystem/internal/models"
+	"errors"
 	"fmt"
 	"log"
 	"net/url"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
-// Client represents a connected client
+// Close codes sent to hub clients, from the application range 4000-4999
+const (
+	CloseRedirect        = 4001
+	CloseSlowConsumer    = 4002
+	CloseIdleTimeout     = 4003
+	CloseSessionReplaced = 4004
+)
+
+// Time allowed to write a frame to the peer
+const writeWait = 10 * time.Second
+
+var (
+	errClientClosed = errors.New("connection closed")
+	errSlowConsumer = errors.New("send queue stayed full")
+)
+
+// SlowConsumerPolicy decides what happens when a client's send queue is full
+type SlowConsumerPolicy string
+
+const (
+	// DropOldest discards the oldest queued frame to make room
+	DropOldest SlowConsumerPolicy = "drop_oldest"
+	// DropNewest discards the frame being sent
+	DropNewest SlowConsumerPolicy = "drop_newest"
+	// Disconnect closes the connection with CloseSlowConsumer
+	Disconnect SlowConsumerPolicy = "disconnect"
+)
+
+// outbound is a single entry in a client's write queue. A non-zero
+// closeCode asks the writer to send a close frame and stop.
+type outbound struct {
+	message   models.Message
+	closeCode int
+	reason    string
+}
+
+// Client represents a connected client. Every write to Conn goes through
+// the send queue, which is drained by a single WritePump goroutine, because
+// gorilla/websocket does not allow concurrent writers.
 type Client struct {
-	ID   string
-	Conn *websocket.Conn
+	ID           string
+	User         string        // Authenticated user, fixed when the connection is upgraded
+	Tenant       string        // Tenant of the authenticated user
+	Scopes       models.Scopes // What the connection's token or ticket allows
+	DeviceID     string
+	Conn         *websocket.Conn
+	hub          *Hub
+	send         chan outbound
+	done         chan struct{}
+	closeOnce    sync.Once
+	policy       SlowConsumerPolicy
+	dropped      atomic.Uint64
+	slowConsumer atomic.Bool
+	backlogged   atomic.Bool // Refused a message that went to the offline queue
+
+	// IDs of messages queued before the history replay finished, which the
+	// replay skips. Nil once the replay is done, or if there won't be one.
+	replayMu     sync.Mutex
+	sentEarly    map[string]struct{}
+	lastActive   atomic.Int64
+	pingInterval time.Duration
+	pongWait     time.Duration
+	idleTimeout  time.Duration
+}
+
+// ClientStats is a snapshot of a client's send queue
+type ClientStats struct {
+	Tenant       string `json:"tenant"`
+	UserID       string `json:"user_id"`
+	DeviceID     string `json:"device_id"`
+	Queued       int    `json:"queued"`
+	Capacity     int    `json:"capacity"`
+	Dropped      uint64 `json:"dropped"`
+	SlowConsumer bool   `json:"slow_consumer"`
+}
+
+// NewClient wraps a connection upgraded for a tenant's authenticated user,
+// granted scopes. Callers must start WritePump.
+func NewClient(conn *websocket.Conn, tenant, user string, scopes models.Scopes) *Client {
+	client := &Client{
+		User:         user,
+		Tenant:       tenant,
+		Scopes:       scopes,
+		Conn:         conn,
+		send:         make(chan outbound, config.AppConfig.SendBufferSize),
+		done:         make(chan struct{}),
+		policy:       SlowConsumerPolicy(config.AppConfig.SlowConsumerPolicy),
+		pingInterval: config.AppConfig.WSPingInterval,
+		pongWait:     config.AppConfig.WSPongWait,
+		idleTimeout:  config.AppConfig.WSIdleTimeout,
+	}
+	if scopes.Has(models.ScopeReadHistory) {
+		client.sentEarly = make(map[string]struct{})
+	}
+	client.Touch()
+
+	// Any pong proves the peer is alive; ReadFrame handles other frames
+	conn.SetReadDeadline(time.Now().Add(client.pongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(client.pongWait))
+	})
+	return client
+}
+
+// ReadFrame reads the next message from the peer, extending the read
+// deadline and marking the client active on success.
+func (c *Client) ReadFrame(msg *models.Message) error {
+	if err := c.Conn.ReadJSON(msg); err != nil {
+		return err
+	}
+	c.Conn.SetReadDeadline(time.Now().Add(c.pongWait))
+	c.Touch()
+	return nil
+}
+
+// Touch records application activity from the peer
+func (c *Client) Touch() {
+	c.lastActive.Store(time.Now().UnixNano())
+}
+
+// idleFor returns how long the peer has gone without sending a message
+func (c *Client) idleFor() time.Duration {
+	return time.Since(time.Unix(0, c.lastActive.Load()))
+}
+
+// Send queues a message for the client without blocking. When the queue is
+// full the client's slow-consumer policy applies, except that ephemeral
+// frames are simply dropped. It returns false if the message was not queued.
+func (c *Client) Send(msg models.Message) bool {
+	select {
+	case <-c.done:
+		return false
+	default:
+	}
+
+	select {
+	case c.send <- outbound{message: msg}:
+		c.queued(msg)
+		return true
+	default:
+	}
+
+	if IsEphemeral(msg.Type) {
+		c.dropped.Add(1)
+		return false
+	}
+
+	switch c.policy {
+	case DropNewest:
+		c.dropped.Add(1)
+		return false
+
+	case Disconnect:
+		c.dropped.Add(1)
+		if !c.slowConsumer.Swap(true) {
+			log.Printf("Disconnecting slow consumer %s", c.ID)
+		}
+		c.closeNow(CloseSlowConsumer, "slow_consumer")
+		return false
+
+	default:
+		// Make room by discarding the oldest frame, retrying a few times
+		// in case the writer or another sender races us for the slot
+		for i := 0; i < 3; i++ {
+			select {
+			case old := <-c.send:
+				if old.closeCode != 0 {
+					// A close was already requested, honour it
+					c.closeNow(old.closeCode, old.reason)
+					return false
+				}
+				c.dropped.Add(1)
+				if old.message.Type == "history" {
+					// A replay with gaps looks complete to the client, so
+					// have it reconnect and replay again instead
+					c.slowConsumer.Store(true)
+					log.Printf("Disconnecting slow consumer %s: history replay overflowed", c.ID)
+					c.closeNow(CloseSlowConsumer, "slow_consumer")
+					return false
+				}
+			default:
+			}
+
+			select {
+			case c.send <- outbound{message: msg}:
+				c.queued(msg)
+				return true
+			default:
+			}
+		}
+		c.dropped.Add(1)
+		return false
+	}
+}
+
+// SendHistory queues a frame of the history replay. Rather than apply the
+// slow-consumer policy it waits up to writeWait for room, since a replay
+// with gaps looks complete to the client. A client that can't take the frame
+// in time is disconnected so it replays again on reconnect. The error says
+// why the frame was not queued.
+func (c *Client) SendHistory(msg models.Message) error {
+	select {
+	case <-c.done:
+		return errClientClosed
+	default:
+	}
+
+	timer := time.NewTimer(writeWait)
+	defer timer.Stop()
+	select {
+	case c.send <- outbound{message: msg}:
+		return nil
+	case <-c.done:
+		return errClientClosed
+	case <-timer.C:
+		c.dropped.Add(1)
+		c.slowConsumer.Store(true)
+		c.closeNow(CloseSlowConsumer, "slow_consumer")
+		return errSlowConsumer
+	}
+}
+
+// queued notes a stored message queued before the history replay finished
+func (c *Client) queued(msg models.Message) {
+	if msg.ID == "" || msg.Type == "history" {
+		return
+	}
+	c.replayMu.Lock()
+	if c.sentEarly != nil {
+		c.sentEarly[msg.ID] = struct{}{}
+	}
+	c.replayMu.Unlock()
+}
+
+// sentBeforeReplay reports whether the client was already sent messageID
+// live or from the offline queue, so the history replay can skip it
+func (c *Client) sentBeforeReplay(messageID string) bool {
+	c.replayMu.Lock()
+	defer c.replayMu.Unlock()
+	_, sent := c.sentEarly[messageID]
+	return sent
+}
+
+// replayFinished stops tracking messages once history has been replayed
+func (c *Client) replayFinished() {
+	c.replayMu.Lock()
+	c.sentEarly = nil
+	c.replayMu.Unlock()
+}
+
+// flushWhenDrained has the client pick up its user's offline queue as soon
+// as its send queue is empty, for messages it refused while full
+func (c *Client) flushWhenDrained() {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.backlogged.Store(true)
+	c.drained()
+}
+
+// drained flushes the offline queue if the client is backlogged and its
+// send queue has emptied
+func (c *Client) drained() {
+	if c.hub != nil && len(c.send) == 0 && c.backlogged.CompareAndSwap(true, false) {
+		go c.hub.FlushOfflineMessages(c)
+	}
+}
+
+// Stats returns a snapshot of the client's send queue
+func (c *Client) Stats() ClientStats {
+	return ClientStats{
+		Tenant:       c.Tenant,
+		UserID:       c.ID,
+		DeviceID:     c.DeviceID,
+		Queued:       len(c.send),
+		Capacity:     cap(c.send),
+		Dropped:      c.dropped.Load(),
+		SlowConsumer: c.slowConsumer.Load(),
+	}
+}
+
+// CloseWithCode sends a close frame after any queued messages and then closes the connection
+func (c *Client) CloseWithCode(code int, reason string) {
+	select {
+	case c.send <- outbound{closeCode: code, reason: reason}:
+	case <-c.done:
+	default:
+		// Queue is full, there is no point waiting behind it
+		c.closeNow(code, reason)
+	}
+}
+
+// closeNow sends a close frame ahead of anything queued and closes the connection.
+// WriteControl is safe to call concurrently with the write pump.
+func (c *Client) closeNow(code int, reason string) {
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	c.Conn.WriteControl(websocket.CloseMessage,
+		websocket.FormatCloseMessage(code, reason),
+		time.Now().Add(writeWait))
+	c.Close()
+}
+
+// Close stops the writer and closes the underlying connection
+func (c *Client) Close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.Conn.Close()
+	})
+}
+
+// Done is closed once the client has been closed
+func (c *Client) Done() <-chan struct{} {
+	return c.done
+}
+
+// WritePump writes queued frames to the connection until the client is closed
+// or a write fails. It also pings the peer and enforces the idle timeout.
+func (c *Client) WritePump() {
+	ticker := time.NewTicker(c.pingInterval)
+	defer func() {
+		ticker.Stop()
+		c.Close()
+	}()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-ticker.C:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if c.idleTimeout > 0 && c.idleFor() > c.idleTimeout {
+				log.Printf("Closing idle connection for %s", c.ID)
+				c.Conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(CloseIdleTimeout, "idle_timeout"))
+				return
+			}
+			if err := c.Conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				log.Printf("Error pinging %s: %v", c.ID, err)
+				return
+			}
+		case out := <-c.send:
+			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+			if out.closeCode != 0 {
+				c.Conn.WriteMessage(websocket.CloseMessage,
+					websocket.FormatCloseMessage(out.closeCode, out.reason))
+				return
+			}
+			if err := c.Conn.WriteJSON(out.message); err != nil {
+				log.Printf("Error writing to %s: %v", c.ID, err)
+				return
+			}
+			if c.hub != nil && isDirectDelivery(out.message, c.ID) {
+				go c.hub.messageDelivered(out.message)
+			}
+			c.drained()
+		}
+	}
+}
+
+// isDirectDelivery reports whether msg is a one-to-one chat message that
+// was just written to its receiver, live, from the offline queue or in a
+// history replay, as opposed to a sync copy or a message already delivered
+func isDirectDelivery(msg models.Message, userID string) bool {
+	switch msg.Type {
+	case "chat":
+	case "history":
+		if msg.Status != models.DeliveryPending {
+			return false
+		}
+	default:
+		return false
+	}
+	return msg.ID != "" && msg.RoomID == "" && msg.Receiver == userID && msg.Sender != userID
+}
+
+// Redirect tells the client to reconnect to the node at url and closes the connection
+func (c *Client) Redirect(url string) {
+	c.Send(models.Message{
+		Text:      url,
+		Sender:    "server",
+		Receiver:  c.ID,
+		Type:      "redirect",
+		Timestamp: time.Now(),
+	})
+	c.CloseWithCode(CloseRedirect, "redirect")
+}
+
+// Replace tells the client a newer connection took over its session and closes it
+func (c *Client) Replace() {
+	c.Send(models.Message{
+		Text:      "Session replaced by a new connection",
+		Sender:    "server",
+		Receiver:  c.ID,
+		Type:      "session_replaced",
+		Timestamp: time.Now(),
+	})
+	c.CloseWithCode(CloseSessionReplaced, "session_replaced")
 }
 
 // Connect connects to the server
@@ -67,8 +470,42 @@ func (c *ChatClient) ListenForMessages() {
 			fmt.Println("✓ Successfully registered!")
 		case "registration_error":
 			fmt.Println("✗ Registration failed:", msg.Text)
+		case "redirect":
+			fmt.Println("↪ Reconnect to:", msg.Text)
+		case "session_replaced":
+			fmt.Println("✗ Signed in from another connection")
 		case "chat":
+			if msg.Quote != nil {
+				fmt.Printf("  > %s: %s\n", msg.Quote.Sender, msg.Quote.Text)
+			}
 			fmt.Printf("[%s] %s: %s\n", msg.Timestamp.Format("15:04:05"), msg.Sender, msg.Text)
+			for _, attachment := range msg.Attachments {
+				fmt.Printf("  📎 %s (%s, %d bytes) %s\n", attachment.Name, attachment.ContentType, attachment.Size, attachment.URL)
+				if attachment.ThumbURL != "" {
+					fmt.Printf("     %dx%d, preview %s\n", attachment.Width, attachment.Height, attachment.ThumbURL)
+				}
+			}
+		case "room":
+			fmt.Printf("[%s] #%s %s: %s\n", msg.Timestamp.Format("15:04:05"), msg.RoomID, msg.Sender, msg.Text)
+		case "typing_start":
+			fmt.Printf("%s is typing...\n", msg.Sender)
+		case "typing_stop":
+		case "message_edited":
+			fmt.Printf("✎ %s edited %s: %s\n", msg.Sender, msg.MessageID, msg.Text)
+		case "message_deleted":
+			fmt.Printf("✗ %s deleted %s\n", msg.Sender, msg.MessageID)
+		case "reaction_add", "reaction_remove":
+			fmt.Printf("%s %s %s on %s\n", msg.Sender, msg.Type, msg.Emoji, msg.MessageID)
+		case "ack":
+			fmt.Printf("✓ sent as %s\n", msg.MessageID)
+		case "delivered", "read":
+			fmt.Printf("✓ %s %s by %s\n", msg.MessageID, msg.Type, msg.Sender)
+		case "broadcast":
+			fmt.Printf("📢 %s: %s\n", msg.Sender, msg.Text)
+		case "history":
+			fmt.Printf("Historical [%s] %s -> %s: %s\n", msg.Timestamp.Format("15:04:05"), msg.Sender, msg.Receiver, msg.Text)
+		case "history_end":
+			fmt.Printf("--- end of history (%s messages) ---\n", msg.Text)
 		default:
 			fmt.Printf("Historical: %s\n", msg.Text)
 		}
@@ -0,0 +1,151 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// withConfig sets a configuration value for the rest of the test
+func withConfig[T any](t *testing.T, field *T, value T) {
+	t.Helper()
+	old := *field
+	*field = value
+	t.Cleanup(func() { *field = old })
+}
+
+// serve accepts websocket connections for a user of the default tenant and
+// reads from them until they fail, as HandleWebSocket does. It returns the
+// URL to dial and the server side of each connection.
+func serve(t *testing.T, hub *Hub, user string) (string, <-chan *Client) {
+	t.Helper()
+	clients := make(chan *Client, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := hub.upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		client := NewClient(conn, models.DefaultTenant, user, models.UserScopes)
+		go client.WritePump()
+		defer client.Close()
+		defer hub.UnregisterClient(client)
+		clients <- client
+
+		for {
+			var msg models.Message
+			if err := client.ReadFrame(&msg); err != nil {
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+	return "ws" + strings.TrimPrefix(server.URL, "http"), clients
+}
+
+// dial opens a websocket connection to url, closed when the test ends
+func dial(t *testing.T, url string) *websocket.Conn {
+	t.Helper()
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dialing %s: %v", url, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// accepted returns the server side of the next connection
+func accepted(t *testing.T, clients <-chan *Client) *Client {
+	t.Helper()
+	select {
+	case client := <-clients:
+		return client
+	case <-time.After(2 * time.Second):
+		t.Fatal("connection was never accepted")
+		return nil
+	}
+}
+
+// closeCode reads from conn until the server closes it and returns the code
+func closeCode(t *testing.T, conn *websocket.Conn) int {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := conn.ReadMessage(); err != nil {
+			var closeErr *websocket.CloseError
+			if !errors.As(err, &closeErr) {
+				t.Fatalf("connection failed without a close frame: %v", err)
+			}
+			return closeErr.Code
+		}
+	}
+}
+
+func TestPeerThatStopsAnsweringPingsIsDropped(t *testing.T) {
+	withConfig(t, &config.AppConfig.WSPingInterval, 20*time.Millisecond)
+	withConfig(t, &config.AppConfig.WSPongWait, 100*time.Millisecond)
+	url, clients := serve(t, newTestHub(t), "alice")
+
+	// Pongs are only sent while reading, so a peer that never reads never answers
+	dial(t, url)
+	client := accepted(t, clients)
+
+	select {
+	case <-client.Done():
+	case <-time.After(2 * time.Second):
+		t.Fatal("a peer that never answered pings is still connected")
+	}
+}
+
+func TestPongsKeepConnectionOpen(t *testing.T) {
+	withConfig(t, &config.AppConfig.WSPingInterval, 20*time.Millisecond)
+	withConfig(t, &config.AppConfig.WSPongWait, 100*time.Millisecond)
+	url, clients := serve(t, newTestHub(t), "alice")
+
+	conn := dial(t, url)
+	go func() {
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	client := accepted(t, clients)
+
+	select {
+	case <-client.Done():
+		t.Fatal("a peer answering pings was dropped")
+	case <-time.After(5 * config.AppConfig.WSPongWait):
+	}
+}
+
+func TestIdleConnectionIsClosed(t *testing.T) {
+	withConfig(t, &config.AppConfig.WSPingInterval, 20*time.Millisecond)
+	withConfig(t, &config.AppConfig.WSIdleTimeout, 150*time.Millisecond)
+	url, clients := serve(t, newTestHub(t), "alice")
+
+	conn := dial(t, url)
+	accepted(t, clients)
+
+	// Messages from the peer keep the connection open past the idle timeout
+	for i := 0; i < 10; i++ {
+		if err := conn.WriteJSON(models.Message{Type: "typing_stop"}); err != nil {
+			t.Fatalf("connection closed while active: %v", err)
+		}
+		time.Sleep(30 * time.Millisecond)
+	}
+
+	start := time.Now()
+	if code := closeCode(t, conn); code != CloseIdleTimeout {
+		t.Errorf("close code = %d, want %d", code, CloseIdleTimeout)
+	}
+	if idle := time.Since(start); idle < 100*time.Millisecond {
+		t.Errorf("closed after %v idle, before the timeout", idle)
+	}
+}
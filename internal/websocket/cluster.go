@@ -0,0 +1,212 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"context"
+	"encoding/json"
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Every instance heartbeats into a shared sorted set and records which users
+// it holds connections for. Messages for users connected elsewhere are
+// published only to the channels of the nodes that hold them, so each message
+// reaches a user exactly once regardless of how many replicas are running.
+const (
+	nodesKey          = "chat:nodes"
+	nodeChannelPrefix = "chat:node:"
+	presencePrefix    = "chat:user_nodes:"
+	broadcastChannel  = "chat:broadcast"
+
+	nodeHeartbeatInterval = 10 * time.Second
+	nodeTTL               = 30 * time.Second
+)
+
+// remoteEnvelope is the payload published between nodes
+type remoteEnvelope struct {
+	Target        string         `json:"target"` // UserKey of the receiver
+	ExcludeDevice string         `json:"exclude_device,omitempty"`
+	QueueOffline  bool           `json:"queue_offline,omitempty"`
+	Origin        string         `json:"origin"`
+	Message       models.Message `json:"message"`
+}
+
+func nodeChannel(nodeID string) string {
+	return nodeChannelPrefix + nodeID
+}
+
+// presenceKey returns the set of nodes holding connections for a UserKey
+func presenceKey(userKey string) string {
+	return presencePrefix + userKey
+}
+
+// MaintainNodePresence keeps this node marked alive and prunes dead nodes
+func (s *Hub) MaintainNodePresence() {
+	if s.rdb == nil {
+		return
+	}
+
+	ticker := time.NewTicker(nodeHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		now := time.Now()
+		_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.ZAdd(ctx, nodesKey, redis.Z{Score: float64(now.Unix()), Member: s.nodeID})
+			pipe.ZRemRangeByScore(ctx, nodesKey, "-inf", strconv.FormatInt(now.Add(-nodeTTL).Unix(), 10))
+			return nil
+		})
+		cancel()
+		if err != nil {
+			log.Printf("Error sending node heartbeat for %s: %v", s.nodeID, err)
+		}
+		<-ticker.C
+	}
+}
+
+// ProcessRemoteMessages delivers messages published to this node by its
+// peers, and broadcasts published by any node
+func (s *Hub) ProcessRemoteMessages() {
+	if s.rdb == nil {
+		return
+	}
+
+	sub := s.rdb.Subscribe(context.Background(), nodeChannel(s.nodeID), broadcastChannel)
+	defer sub.Close()
+
+	for payload := range sub.Channel() {
+		var envelope remoteEnvelope
+		if err := json.Unmarshal([]byte(payload.Payload), &envelope); err != nil {
+			log.Printf("Error decoding remote message: %v", err)
+			continue
+		}
+
+		if payload.Channel == broadcastChannel {
+			// The originating node has already delivered to its own clients
+			if envelope.Origin != s.nodeID {
+				s.broadcastLocal(envelope.Message)
+			}
+			continue
+		}
+
+		if !s.deliverLocal(envelope.Target, envelope.Message, envelope.ExcludeDevice) && envelope.QueueOffline {
+			// The user left between the presence lookup and delivery, or
+			// their devices are too busy to take it now
+			s.queueOfflineMessage(envelope.Target, envelope.Message)
+			s.flushWhenDrained(envelope.Target)
+		}
+	}
+}
+
+// registerPresence records that userKey is connected to this node
+func (s *Hub) registerPresence(userKey string) {
+	if s.rdb == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.rdb.SAdd(ctx, presenceKey(userKey), s.nodeID).Err(); err != nil {
+		log.Printf("Error registering presence for %s: %v", userKey, err)
+	}
+}
+
+// unregisterPresence records that userKey is no longer connected to this node
+func (s *Hub) unregisterPresence(userKey string) {
+	if s.rdb == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := s.rdb.SRem(ctx, presenceKey(userKey), s.nodeID).Err(); err != nil {
+		log.Printf("Error removing presence for %s: %v", userKey, err)
+	}
+}
+
+// liveNodesFor returns the other live nodes holding a connection for userKey.
+// Entries left behind by nodes that stopped heartbeating are pruned.
+func (s *Hub) liveNodesFor(ctx context.Context, userKey string) ([]string, error) {
+	nodes, err := s.rdb.SMembers(ctx, presenceKey(userKey)).Result()
+	if err != nil || len(nodes) == 0 {
+		return nil, err
+	}
+
+	scores, err := s.rdb.ZMScore(ctx, nodesKey, nodes...).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := float64(time.Now().Add(-nodeTTL).Unix())
+	live := make([]string, 0, len(nodes))
+	for i, node := range nodes {
+		if scores[i] < cutoff {
+			s.rdb.SRem(ctx, presenceKey(userKey), node)
+			continue
+		}
+		if node != s.nodeID {
+			live = append(live, node)
+		}
+	}
+	return live, nil
+}
+
+// publishToNodes forwards an envelope to every other node holding a
+// connection for its target. It reports whether at least one node accepted it.
+func (s *Hub) publishToNodes(envelope remoteEnvelope) bool {
+	if s.rdb == nil {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	userKey := envelope.Target
+	nodes, err := s.liveNodesFor(ctx, userKey)
+	if err != nil {
+		log.Printf("Error looking up presence for %s: %v", userKey, err)
+		return false
+	}
+
+	envelope.Origin = s.nodeID
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		log.Printf("Error encoding remote message for %s: %v", userKey, err)
+		return false
+	}
+
+	published := false
+	for _, node := range nodes {
+		if err := s.rdb.Publish(ctx, nodeChannel(node), payload).Err(); err != nil {
+			log.Printf("Error publishing message for %s to node %s: %v", userKey, node, err)
+			continue
+		}
+		published = true
+	}
+	return published
+}
+
+// publishBroadcast sends msg to every other node in the cluster
+func (s *Hub) publishBroadcast(msg models.Message) {
+	if s.rdb == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	payload, err := json.Marshal(remoteEnvelope{Origin: s.nodeID, Message: msg})
+	if err != nil {
+		log.Printf("Error encoding broadcast: %v", err)
+		return
+	}
+	if err := s.rdb.Publish(ctx, broadcastChannel, payload).Err(); err != nil {
+		log.Printf("Error publishing broadcast: %v", err)
+	}
+}
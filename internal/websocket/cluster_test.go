@@ -0,0 +1,99 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestCluster returns hubs for nodeIDs sharing one database and Redis
+// server, each heartbeating and listening for messages from its peers
+func newTestCluster(t *testing.T, nodeIDs ...string) []*Hub {
+	t.Helper()
+	db, rdb := newTestDB(t), newTestRedis(t)
+
+	hubs := make([]*Hub, 0, len(nodeIDs))
+	for _, nodeID := range nodeIDs {
+		hub := newTestNode(t, db, rdb, nodeID)
+		heartbeat(t, hub, time.Now())
+		go hub.ProcessRemoteMessages()
+		hubs = append(hubs, hub)
+	}
+
+	// Messages published before a node subscribes are lost
+	deadline := time.Now().Add(2 * time.Second)
+	for _, nodeID := range nodeIDs {
+		for rdb.PubSubNumSub(context.Background(), nodeChannel(nodeID)).Val()[nodeChannel(nodeID)] == 0 {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s never subscribed", nodeID)
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	return hubs
+}
+
+// heartbeat records hub as alive at the given time
+func heartbeat(t *testing.T, hub *Hub, at time.Time) {
+	t.Helper()
+	err := hub.rdb.ZAdd(context.Background(), nodesKey, redis.Z{Score: float64(at.Unix()), Member: hub.nodeID}).Err()
+	if err != nil {
+		t.Fatalf("heartbeat: %v", err)
+	}
+}
+
+func TestMessageReachesUserOnAnotherNode(t *testing.T) {
+	nodes := newTestCluster(t, "node-a", "node-b")
+	alice := connect(nodes[0], "alice", "phone")
+	aliceTablet := connect(nodes[1], "alice", "tablet")
+	bob := connect(nodes[1], "bob", "laptop")
+
+	nodes[0].Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+
+	msg := next(t, bob, "chat")
+	if msg.Text != "hi" || msg.Sender != "alice" {
+		t.Errorf("bob got %+v", msg)
+	}
+	if got := next(t, aliceTablet, "chat"); got.ID != msg.ID {
+		t.Errorf("alice's tablet got %s, want a copy of %s", got.ID, msg.ID)
+	}
+	none(t, bob, "chat")
+	none(t, alice, "chat")
+}
+
+func TestMessageReachesDevicesOnEveryNodeOnce(t *testing.T) {
+	nodes := newTestCluster(t, "node-a", "node-b", "node-c")
+	alice := connect(nodes[0], "alice", "phone")
+	bobPhone := connect(nodes[0], "bob", "phone")
+	bobLaptop := connect(nodes[1], "bob", "laptop")
+
+	nodes[0].Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+
+	for _, device := range []*Client{bobPhone, bobLaptop} {
+		next(t, device, "chat")
+		none(t, device, "chat")
+	}
+	if queued := nodes[0].rdb.LLen(context.Background(), offlineQueueKey(UserKey(models.DefaultTenant, "bob"))).Val(); queued != 0 {
+		t.Errorf("%d messages queued offline for a connected user", queued)
+	}
+}
+
+func TestDeadNodeIsSkipped(t *testing.T) {
+	nodes := newTestCluster(t, "node-a", "node-b")
+	alice := connect(nodes[0], "alice", "phone")
+	bob := connect(nodes[1], "bob", "laptop")
+
+	// node-b stops heartbeating while bob's presence entry is still there
+	heartbeat(t, nodes[1], time.Now().Add(-2*nodeTTL))
+	nodes[0].Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+
+	waitForOfflineQueue(t, nodes[0], "bob", 1)
+	none(t, bob, "chat")
+	presence := nodes[0].rdb.SMembers(context.Background(), presenceKey(UserKey(models.DefaultTenant, "bob"))).Val()
+	if len(presence) != 0 {
+		t.Errorf("bob's presence = %v, want the dead node pruned", presence)
+	}
+}
@@ -0,0 +1,143 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"sync"
+	"time"
+)
+
+// Ephemeral frames are relayed straight to their peers: they are never
+// persisted or queued for offline users, and under backpressure they are
+// dropped rather than displacing real messages.
+var ephemeralTypes = map[string]bool{
+	"typing_start": true,
+	"typing_stop":  true,
+}
+
+// IsEphemeral reports whether frames of msgType are ephemeral signals
+func IsEphemeral(msgType string) bool {
+	return ephemeralTypes[msgType]
+}
+
+// typingTracker expires typing indicators whose typing_stop never arrives,
+// and throttles the typing_start frames clients repeat while typing
+type typingTracker struct {
+	mu     sync.Mutex
+	active map[string]*typingIndicator
+}
+
+type typingIndicator struct {
+	timer   *time.Timer
+	relayed time.Time // When typing_start was last relayed to peers
+}
+
+func newTypingTracker() *typingTracker {
+	return &typingTracker{active: make(map[string]*typingIndicator)}
+}
+
+// start (re)arms the expiry for key, calling expire if it runs out. It
+// reports whether typing_start should be relayed: when typing begins, and
+// again once half the timeout has passed so peers keep showing it. Clients
+// sending it on every keystroke then cost at most two relays per timeout.
+func (t *typingTracker) start(key string, timeout time.Duration, expire func()) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	indicator, exists := t.active[key]
+	if exists {
+		indicator.timer.Stop()
+	} else {
+		indicator = &typingIndicator{}
+		t.active[key] = indicator
+	}
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		t.mu.Lock()
+		current := t.active[key] == indicator && indicator.timer == timer
+		if current {
+			delete(t.active, key)
+		}
+		t.mu.Unlock()
+
+		if current {
+			expire()
+		}
+	})
+	indicator.timer = timer
+
+	if exists && time.Since(indicator.relayed) < timeout/2 {
+		return false
+	}
+	indicator.relayed = time.Now()
+	return true
+}
+
+// stop cancels the expiry for key and reports whether it was typing
+func (t *typingTracker) stop(key string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	indicator, exists := t.active[key]
+	if exists {
+		indicator.timer.Stop()
+		delete(t.active, key)
+	}
+	return exists
+}
+
+// SendEphemeral relays an ephemeral signal from client to its conversation
+// peer, or to the other members of a room. Repeated typing_start frames are
+// throttled, and typing_stop is only relayed while the client is typing.
+func (s *Hub) SendEphemeral(client *Client, msg models.Message) {
+	if client.ID == "" || (msg.Receiver == "" && msg.RoomID == "") {
+		return
+	}
+
+	msg.Sender = client.ID
+	msg.Tenant = client.Tenant
+	msg.DeviceID = client.DeviceID
+	msg.Timestamp = time.Now()
+
+	key := UserKey(client.Tenant, client.ID) + "|" + client.DeviceID + "|" + msg.Receiver + "|" + msg.RoomID
+	switch msg.Type {
+	case "typing_start":
+		stop := msg
+		stop.Type = "typing_stop"
+		relay := s.typing.start(key, config.AppConfig.TypingTimeout, func() {
+			stop.Timestamp = time.Now()
+			s.relayEphemeral(stop)
+		})
+		if !relay {
+			return
+		}
+	case "typing_stop":
+		if !s.typing.stop(key) {
+			return
+		}
+	}
+
+	s.relayEphemeral(msg)
+}
+
+func (s *Hub) relayEphemeral(msg models.Message) {
+	if msg.RoomID != "" {
+		rooms := s.roomService.ForTenant(msg.Tenant)
+		member, err := rooms.IsMember(msg.RoomID, msg.Sender)
+		if err != nil || !member {
+			return
+		}
+		members, err := rooms.GetMembers(msg.RoomID)
+		if err != nil {
+			return
+		}
+		for _, userID := range members {
+			if userID != msg.Sender {
+				s.deliverToUser(msg.Tenant, userID, msg, "", false)
+			}
+		}
+		return
+	}
+
+	s.deliverToUser(msg.Tenant, msg.Receiver, msg, "", false)
+}
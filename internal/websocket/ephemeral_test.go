@@ -0,0 +1,127 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTypingRelayedToPeer(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	hub.SendEphemeral(alice, models.Message{Sender: "mallory", Receiver: "bob", Type: "typing_start"})
+	if got := next(t, bob, "typing_start"); got.Sender != "alice" {
+		t.Errorf("typing from %q, want the connection's user", got.Sender)
+	}
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_stop"})
+	next(t, bob, "typing_stop")
+}
+
+func TestTypingIsNotQueuedOffline(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_start"})
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_stop"})
+
+	key := offlineQueueKey(UserKey(models.DefaultTenant, "bob"))
+	if queued := hub.rdb.LLen(context.Background(), key).Val(); queued != 0 {
+		t.Errorf("%d typing frames queued for an offline user", queued)
+	}
+}
+
+func TestTypingExpiresWithoutStop(t *testing.T) {
+	withConfig(t, &config.AppConfig.TypingTimeout, 50*time.Millisecond)
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_start"})
+	next(t, bob, "typing_start")
+	if got := next(t, bob, "typing_stop"); got.Sender != "alice" {
+		t.Errorf("expired typing_stop = %+v", got)
+	}
+
+	// An explicit stop cancels the expiry, so only one stop arrives
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_start"})
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_stop"})
+	next(t, bob, "typing_stop")
+	time.Sleep(2 * config.AppConfig.TypingTimeout)
+	none(t, bob, "typing_stop")
+}
+
+func TestTypingInRoomReachesOtherMembers(t *testing.T) {
+	hub := newTestHub(t)
+	rooms := hub.roomService.ForTenant(models.DefaultTenant)
+	room, err := rooms.CreateRoom("team", "alice", true)
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	if err := rooms.JoinRoom(room.ID, "bob"); err != nil {
+		t.Fatalf("JoinRoom: %v", err)
+	}
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	carol := connect(hub, "carol", "tablet")
+
+	hub.SendEphemeral(alice, models.Message{RoomID: room.ID, Type: "typing_start"})
+	next(t, bob, "typing_start")
+	none(t, alice, "typing_start")
+	none(t, carol, "typing_start")
+
+	// Non-members can't signal into the room
+	hub.SendEphemeral(carol, models.Message{RoomID: room.ID, Type: "typing_start"})
+	none(t, bob, "typing_start")
+}
+
+func TestTypingDroppedWhenQueueIsFull(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connectWithQueue(hub, "bob", "laptop", 1, DropOldest)
+
+	bob.Send(models.Message{ID: "m1", Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat"})
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_start"})
+
+	if got := next(t, bob, "chat"); got.ID != "m1" {
+		t.Errorf("queued message = %+v, want it kept over the typing frame", got)
+	}
+	if bob.dropped.Load() != 1 {
+		t.Errorf("dropped = %d, want the typing frame counted", bob.dropped.Load())
+	}
+}
+
+func TestRepeatedTypingIsThrottled(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	// A client sending typing_start on every keystroke
+	for i := 0; i < 5; i++ {
+		hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_start"})
+	}
+	next(t, bob, "typing_start")
+	none(t, bob, "typing_start")
+
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_stop"})
+	next(t, bob, "typing_stop")
+	// Nothing is typing any more, so a second stop isn't relayed
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_stop"})
+	none(t, bob, "typing_stop")
+}
+
+func TestTypingIsRelayedAgainBeforePeersExpireIt(t *testing.T) {
+	withConfig(t, &config.AppConfig.TypingTimeout, 100*time.Millisecond)
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_start"})
+	next(t, bob, "typing_start")
+	time.Sleep(config.AppConfig.TypingTimeout / 2)
+	hub.SendEphemeral(alice, models.Message{Receiver: "bob", Type: "typing_start"})
+	next(t, bob, "typing_start")
+}
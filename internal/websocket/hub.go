@@ -1,71 +1,252 @@
 package websocket
 
 import (
+	"chatsystem/internal/config"
 	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"hash/fnv"
 	"net/http"
+	"sort"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gorilla/websocket"
+	"github.com/redis/go-redis/v9"
 )
 
 // Server represents the central server
 type Hub struct {
-	clients     map[string]*Client
-	mutex       sync.RWMutex
-	persistChan chan models.Message
-	chatChan    chan models.Message
-	historyChan chan string
-	upgrader    websocket.Upgrader
+	clients       map[string]map[string]*Client // UserKey -> deviceID -> connection
+	connections   map[string]int                // tenant -> connections on this node
+	mutex         sync.RWMutex
+	persistChans  []chan models.Message // One queue per persist worker, see persistQueue
+	chatChan      chan models.Message
+	historyChan   chan models.QueryMessage
+	upgrader      websocket.Upgrader
+	chatService   *services.ChatService
+	roomService   *services.RoomService
+	tenantService *services.TenantService
+	rdb           *redis.Client
+	nodeID        string
+	router        *Router
+	typing        *typingTracker
+
+	// Counters carried over from clients that have left the hub
+	retiredDropped  atomic.Uint64
+	slowDisconnects atomic.Uint64
+}
+
+// HubStats summarises send-queue pressure across the hub
+type HubStats struct {
+	NodeID          string        `json:"node_id"`
+	Policy          string        `json:"policy"`
+	Connected       int           `json:"connected"`
+	TotalDropped    uint64        `json:"total_dropped"`
+	SlowDisconnects uint64        `json:"slow_disconnects"`
+	Clients         []ClientStats `json:"clients"`
 }
 
+// persistWorkers is how many conversations are stored and updated at once
+const persistWorkers = 16
+
 // NewHub creates a new hub instance
-func NewHub() *Hub {
-	return &Hub{
-		clients:     make(map[string]*Client),
-		persistChan: make(chan models.Message, 100),
-		chatChan:    make(chan models.Message, 100),
-		historyChan: make(chan string, 100),
+func NewHub(chatService *services.ChatService, roomService *services.RoomService, tenantService *services.TenantService, rdb *redis.Client) *Hub {
+	hub := &Hub{
+		clients:      make(map[string]map[string]*Client),
+		connections:  make(map[string]int),
+		persistChans: make([]chan models.Message, persistWorkers),
+		chatChan:     make(chan models.Message, 100),
+		historyChan:  make(chan models.QueryMessage, 100),
 		upgrader: websocket.Upgrader{
 			CheckOrigin: func(r *http.Request) bool {
 				return true
 			},
 		},
+		chatService:   chatService,
+		roomService:   roomService,
+		tenantService: tenantService,
+		rdb:           rdb,
+		nodeID:        config.AppConfig.NodeID,
+		typing:        newTypingTracker(),
+		router:        NewRouter(config.AppConfig.NodeID, config.AppConfig.NodeAdvertiseURL, rdb),
+	}
+	for i := range hub.persistChans {
+		hub.persistChans[i] = make(chan models.Message, 100)
 	}
+	hub.router.onRebalance = hub.rebalance
+	return hub
+}
+
+// Router returns the hub's user-to-node router
+func (s *Hub) Router() *Router {
+	return s.router
 }
 
-// RegisterUser registers a new user
-func (s *Hub) RegisterUser(userID string, conn *websocket.Conn) bool {
+// Policies for a new_client from a device that is already connected
+const (
+	RejectDuplicateSessions   = "reject"
+	TakeoverDuplicateSessions = "takeover"
+)
+
+// DefaultDeviceID is used for clients that do not identify their device
+const DefaultDeviceID = "default"
+
+// RegisterUser registers a device connection for a user of the client's
+// tenant. A user may hold one connection per device. If the device is already
+// connected the new connection is rejected, or under the takeover policy the
+// old connection is told its session was replaced and closed. New devices are
+// refused once the tenant holds its maximum connections on this node.
+func (s *Hub) RegisterUser(userID, deviceID string, client *Client) error {
+	if deviceID == "" {
+		deviceID = DefaultDeviceID
+	}
+	if !s.TenantEnabled(client.Tenant) {
+		return ErrTenantDisabled
+	}
+	limit := s.maxConnections(client.Tenant)
+	key := UserKey(client.Tenant, userID)
+
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	existing, replacing := s.clients[key][deviceID]
+	if replacing && config.AppConfig.DuplicateSession != TakeoverDuplicateSessions {
+		s.mutex.Unlock()
+		return ErrDuplicateSession
+	}
+	if !replacing && limit > 0 && s.connections[client.Tenant] >= limit {
+		s.mutex.Unlock()
+		return ErrTenantFull
+	}
+
+	devices, exists := s.clients[key]
+	if !exists {
+		devices = make(map[string]*Client)
+		s.clients[key] = devices
+	}
+	client.ID = userID
+	client.DeviceID = deviceID
+	client.hub = s
+	devices[deviceID] = client
+	if !replacing {
+		s.connections[client.Tenant]++
+	}
+	s.mutex.Unlock()
 
-	if _, exists := s.clients[userID]; exists {
-		return false
+	if replacing {
+		s.retireClient(existing)
+		existing.Replace()
 	}
+	s.registerPresence(key)
+	return nil
+}
 
-	s.clients[userID] = &Client{
-		ID:   userID,
-		Conn: conn,
+// RemoveUser disconnects every device of a tenant's user
+func (s *Hub) RemoveUser(tenant, userID string) {
+	for _, client := range s.GetClients(tenant, userID) {
+		s.UnregisterClient(client)
+		client.Close()
 	}
-	return true
 }
 
-// RemoveUser removes a user from the server
-func (s *Hub) RemoveUser(userID string) {
+// UnregisterClient drops client from the hub without closing it. It does
+// nothing if the device's slot is held by a different connection.
+func (s *Hub) UnregisterClient(client *Client) bool {
+	key := UserKey(client.Tenant, client.ID)
+
 	s.mutex.Lock()
-	defer s.mutex.Unlock()
+	devices := s.clients[key]
+	removed := devices != nil && devices[client.DeviceID] == client
+	lastDevice := false
+	if removed {
+		delete(devices, client.DeviceID)
+		if len(devices) == 0 {
+			delete(s.clients, key)
+			lastDevice = true
+		}
+		if s.connections[client.Tenant]--; s.connections[client.Tenant] <= 0 {
+			delete(s.connections, client.Tenant)
+		}
+	}
+	s.mutex.Unlock()
+
+	if removed {
+		s.retireClient(client)
+	}
+	if lastDevice {
+		s.unregisterPresence(key)
+	}
+	return removed
+}
+
+// retireClient folds a departing client's counters into the hub totals
+func (s *Hub) retireClient(client *Client) {
+	s.retiredDropped.Add(client.dropped.Load())
+	if client.slowConsumer.Load() {
+		s.slowDisconnects.Add(1)
+	}
+}
+
+// Stats reports per-client queue depth and drop counters, most backed-up
+// first. An empty tenant reports on every client.
+func (s *Hub) Stats(tenant string) HubStats {
+	s.mutex.RLock()
+	clients := make([]ClientStats, 0, len(s.clients))
+	for _, devices := range s.clients {
+		for _, client := range devices {
+			if tenant == "" || client.Tenant == tenant {
+				clients = append(clients, client.Stats())
+			}
+		}
+	}
+	s.mutex.RUnlock()
+
+	sort.Slice(clients, func(i, j int) bool {
+		if clients[i].Queued != clients[j].Queued {
+			return clients[i].Queued > clients[j].Queued
+		}
+		return clients[i].Dropped > clients[j].Dropped
+	})
+
+	stats := HubStats{
+		NodeID:          s.nodeID,
+		Policy:          config.AppConfig.SlowConsumerPolicy,
+		Connected:       len(clients),
+		TotalDropped:    s.retiredDropped.Load(),
+		SlowDisconnects: s.slowDisconnects.Load(),
+		Clients:         clients,
+	}
+	for _, client := range clients {
+		stats.TotalDropped += client.Dropped
+		if client.SlowConsumer {
+			stats.SlowDisconnects++
+		}
+	}
+	return stats
+}
 
-	if client, exists := s.clients[userID]; exists {
-		client.Conn.Close()
-		delete(s.clients, userID)
+// GetClients returns every connected device of a tenant's user
+func (s *Hub) GetClients(tenant, userID string) []*Client {
+	return s.clientsFor(UserKey(tenant, userID))
+}
+
+// clientsFor returns the connected devices for a UserKey
+func (s *Hub) clientsFor(key string) []*Client {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+
+	devices := s.clients[key]
+	clients := make([]*Client, 0, len(devices))
+	for _, client := range devices {
+		clients = append(clients, client)
 	}
+	return clients
 }
 
-// GetClient returns a client by ID
-func (s *Hub) GetClient(userID string) (*Client, bool) {
+// GetClient returns the connection of one of a tenant's user's devices
+func (s *Hub) GetClient(tenant, userID, deviceID string) (*Client, bool) {
 	s.mutex.RLock()
 	defer s.mutex.RUnlock()
 
-	client, exists := s.clients[userID]
+	client, exists := s.clients[UserKey(tenant, userID)][deviceID]
 	return client, exists
 }
 
@@ -74,5 +255,39 @@ func (s *Hub) SendToChat(msg models.Message) {
 }
 
 func (s *Hub) SendToPersist(msg models.Message) {
-	s.persistChan <- msg
+	s.persistQueue(msg) <- msg
+}
+
+// persistQueue picks the persist worker for msg. New messages are spread by
+// conversation, the room or the pair of users, so a reply is always checked
+// after its parent is stored. Receipts and changes are spread by the message
+// they refer to, so they are applied in the order they were sent.
+func (s *Hub) persistQueue(msg models.Message) chan models.Message {
+	var key string
+	switch msg.Type {
+	case "delivered", "read", "edit", "delete", "reaction_add", "reaction_remove":
+		key = "message:" + msg.MessageID
+	default:
+		if msg.RoomID != "" {
+			key = "room:" + msg.RoomID
+		} else {
+			users := []string{msg.Sender, msg.Receiver}
+			sort.Strings(users)
+			key = "pair:" + users[0] + ":" + users[1]
+		}
+	}
+
+	h := fnv.New32a()
+	h.Write([]byte(msg.Tenant + ":" + key))
+	return s.persistChans[h.Sum32()%uint32(len(s.persistChans))]
+}
+
+// FetchHistoricalMessages queues a history replay for a newly registered device
+func (s *Hub) FetchHistoricalMessages(tenant, userID, deviceID string) {
+	s.historyChan <- models.QueryMessage{
+		Tenant:   tenant,
+		UserID:   userID,
+		DeviceID: deviceID,
+		Limit:    s.historyLimit(tenant),
+	}
 }
@@ -0,0 +1,77 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestDuplicateDeviceIsRejected(t *testing.T) {
+	hub := newTestHub(t)
+	url, clients := serve(t, hub, "alice")
+
+	dial(t, url)
+	first := accepted(t, clients)
+	if err := hub.RegisterUser("alice", "phone", first); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+
+	dial(t, url)
+	second := accepted(t, clients)
+	if err := hub.RegisterUser("alice", "phone", second); !errors.Is(err, ErrDuplicateSession) {
+		t.Errorf("second phone = %v, want ErrDuplicateSession", err)
+	}
+	if err := hub.RegisterUser("alice", "laptop", second); err != nil {
+		t.Errorf("another device: %v", err)
+	}
+	if client, _ := hub.GetClient(models.DefaultTenant, "alice", "phone"); client != first {
+		t.Error("the rejected connection replaced the first one")
+	}
+}
+
+func TestTakeoverReplacesOldSession(t *testing.T) {
+	withConfig(t, &config.AppConfig.DuplicateSession, TakeoverDuplicateSessions)
+	hub := newTestHub(t)
+	url, clients := serve(t, hub, "alice")
+
+	oldConn := dial(t, url)
+	old := accepted(t, clients)
+	if err := hub.RegisterUser("alice", "phone", old); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	newConn := dial(t, url)
+	current := accepted(t, clients)
+	if err := hub.RegisterUser("alice", "phone", current); err != nil {
+		t.Fatalf("takeover: %v", err)
+	}
+
+	oldConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var notice models.Message
+	if err := oldConn.ReadJSON(&notice); err != nil || notice.Type != "session_replaced" {
+		t.Fatalf("old session got %+v, %v, want session_replaced", notice, err)
+	}
+	if code := closeCode(t, oldConn); code != CloseSessionReplaced {
+		t.Errorf("close code = %d, want %d", code, CloseSessionReplaced)
+	}
+
+	// The old connection leaving must not take the new one with it
+	<-old.Done()
+	if hub.UnregisterClient(old) {
+		t.Error("unregistering the replaced connection removed the device")
+	}
+	if client, _ := hub.GetClient(models.DefaultTenant, "alice", "phone"); client != current {
+		t.Fatal("the new connection doesn't hold the device")
+	}
+	if connected := hub.Stats("").Connected; connected != 1 {
+		t.Errorf("connected = %d, want 1", connected)
+	}
+
+	hub.deliverToUser(models.DefaultTenant, "alice", models.Message{Sender: "bob", Receiver: "alice", Text: "hi", Type: "chat"}, "", false)
+	newConn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	var msg models.Message
+	if err := newConn.ReadJSON(&msg); err != nil || msg.Text != "hi" {
+		t.Errorf("new session got %+v, %v", msg, err)
+	}
+}
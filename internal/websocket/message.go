@@ -1,19 +1,336 @@
 package websocket
 
-import "chatsystem/internal/services"
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+)
 
-// ProcessChatMessages processes chat messages from channel
+const idempotencyPrefix = "chat:idem:"
+
+// Submit assigns a server ID to a message from client and queues it for
+// storage. Messages are only delivered and acknowledged once stored, see
+// storeMessage, so receipts and replies always find the message they refer
+// to. A retry carrying an idempotency key already seen within the window is
+// acknowledged with the original ID and not delivered again. Messages beyond
+// the tenant's per-minute limit are refused.
+func (s *Hub) Submit(client *Client, msg models.Message) {
+	msg.ID = services.NewID()
+	msg.Tenant = client.Tenant
+	msg.Quote = nil
+	chat := s.chatService.ForTenant(client.Tenant)
+
+	if len(msg.Attachments) > 0 {
+		attachments, err := chat.ResolveAttachments(msg.Sender, msg.Attachments)
+		if err != nil {
+			text := "Could not load attachments"
+			switch {
+			case errors.Is(err, services.ErrAttachmentNotFound):
+				text = "Attachment not found"
+			case errors.Is(err, services.ErrAttachmentInUse):
+				text = "Attachment already sent in another message"
+			default:
+				log.Printf("Error resolving attachments for %s: %v", msg.Sender, err)
+			}
+			s.sendMessageError(client, "", text)
+			return
+		}
+		msg.Attachments = attachments
+	}
+
+	if msg.IdempotencyKey != "" {
+		if original, duplicate := s.claimIdempotencyKey(msg.Tenant, msg.Sender, msg.IdempotencyKey, msg.ID); duplicate {
+			s.acknowledge(client, original, msg.IdempotencyKey)
+			return
+		}
+	}
+
+	if !s.allowMessage(msg.Tenant) {
+		// The message was never stored, so its retry must not be acknowledged
+		if msg.IdempotencyKey != "" {
+			s.releaseIdempotencyKey(msg.Tenant, msg.Sender, msg.IdempotencyKey)
+		}
+		s.sendMessageError(client, "", "Message rate limit reached, try again shortly")
+		return
+	}
+
+	s.SendToPersist(msg)
+}
+
+// storeMessage checks that a reply's parent is in the same conversation and
+// quotes it, stores the message, then hands it on for delivery and
+// acknowledges it to the sending device. Messages that can't be stored are
+// refused rather than delivered. It runs on the persist worker of the
+// message's conversation, so a parent submitted earlier is always stored by
+// the time its replies are checked.
+func (s *Hub) storeMessage(msg models.Message) {
+	chat := s.chatService.ForTenant(msg.Tenant)
+	client, connected := s.GetClient(msg.Tenant, msg.Sender, msg.DeviceID)
+
+	if msg.ParentID != "" {
+		parent, err := chat.GetParent(msg)
+		if err != nil {
+			text := "Could not load the message being replied to"
+			switch {
+			case errors.Is(err, services.ErrMessageNotFound):
+				text = "Parent message not found"
+			case errors.Is(err, services.ErrInvalidParent):
+				text = "Parent message is not in this conversation"
+			default:
+				log.Printf("Error loading parent %s: %v", msg.ParentID, err)
+			}
+			// Let a corrected retry through under the same key
+			if msg.IdempotencyKey != "" {
+				s.releaseIdempotencyKey(msg.Tenant, msg.Sender, msg.IdempotencyKey)
+			}
+			if connected {
+				s.sendMessageError(client, msg.ParentID, text)
+			}
+			return
+		}
+		msg.Quote = parent.ToQuote()
+	}
+
+	if err := chat.SaveMessage(msg); err != nil {
+		log.Printf("Error persisting message from %s to %s: %v", msg.Sender, msg.Receiver, err)
+		// Nothing was stored, so the sender may retry under the same key
+		if msg.IdempotencyKey != "" {
+			s.releaseIdempotencyKey(msg.Tenant, msg.Sender, msg.IdempotencyKey)
+		}
+		if connected {
+			s.sendMessageError(client, "", "Could not store the message, try again")
+		}
+		return
+	}
+	s.SendToChat(msg)
+	if connected {
+		s.acknowledge(client, msg.ID, msg.IdempotencyKey)
+	}
+}
+
+// acknowledge tells the sending device which ID the server assigned
+func (s *Hub) acknowledge(client *Client, messageID, idempotencyKey string) {
+	client.Send(models.Message{
+		Sender:         "server",
+		Receiver:       client.ID,
+		Type:           "ack",
+		MessageID:      messageID,
+		IdempotencyKey: idempotencyKey,
+		Timestamp:      time.Now(),
+	})
+}
+
+// claimIdempotencyKey records messageID against a sender's idempotency key.
+// If the key was already claimed it returns the original message ID and true.
+func (s *Hub) claimIdempotencyKey(tenant, sender, key, messageID string) (string, bool) {
+	if s.rdb == nil {
+		return messageID, false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	redisKey := idempotencyPrefix + UserKey(tenant, sender) + ":" + key
+	claimed, err := s.rdb.SetNX(ctx, redisKey, messageID, config.AppConfig.IdempotencyWindow).Result()
+	if err != nil {
+		// Prefer a possible duplicate over dropping the message
+		log.Printf("Error checking idempotency key for %s: %v", sender, err)
+		return messageID, false
+	}
+	if claimed {
+		return messageID, false
+	}
+
+	original, err := s.rdb.Get(ctx, redisKey).Result()
+	if err != nil {
+		log.Printf("Error loading idempotency key for %s: %v", sender, err)
+		return messageID, false
+	}
+	return original, true
+}
+
+// releaseIdempotencyKey forgets a sender's idempotency key, for messages
+// that were refused after claiming it
+func (s *Hub) releaseIdempotencyKey(tenant, sender, key string) {
+	if s.rdb == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	redisKey := idempotencyPrefix + UserKey(tenant, sender) + ":" + key
+	if err := s.rdb.Del(ctx, redisKey).Err(); err != nil {
+		log.Printf("Error releasing idempotency key for %s: %v", sender, err)
+	}
+}
+
+// ProcessChatMessages processes chat messages from channel. Messages are
+// fanned out to every device of the receiver, and the sender's other devices
+// get a copy so their conversation stays in sync.
 func (s *Hub) ProcessChatMessages() {
 	for msg := range s.chatChan {
-		if receiver, exists := s.GetClient(msg.Receiver); exists {
-			receiver.Conn.WriteJSON(msg)
+		if msg.Type == "room" {
+			s.deliverToRoom(msg)
+			continue
+		}
+
+		s.deliverToUser(msg.Tenant, msg.Receiver, msg, "", true)
+		if msg.Sender != msg.Receiver {
+			s.deliverToUser(msg.Tenant, msg.Sender, msg, msg.DeviceID, false)
+		}
+	}
+}
+
+// deliverToUser sends msg to every device of a tenant's user except
+// excludeDevice, on this node and on any other node holding a connection for
+// the user. When no device takes the message it is queued for later if
+// queueOffline is set, and connected devices that were too busy pick it up
+// once they catch up. It reports whether any device took the message.
+func (s *Hub) deliverToUser(tenant, userID string, msg models.Message, excludeDevice string, queueOffline bool) bool {
+	msg.Tenant = tenant
+	key := UserKey(tenant, userID)
+	delivered := s.deliverLocal(key, msg, excludeDevice)
+	if s.publishToNodes(remoteEnvelope{
+		Target:        key,
+		ExcludeDevice: excludeDevice,
+		QueueOffline:  queueOffline,
+		Message:       msg,
+	}) {
+		delivered = true
+	}
+	if !delivered && queueOffline {
+		s.queueOfflineMessage(key, msg)
+		s.flushWhenDrained(key)
+	}
+	return delivered
+}
+
+// flushWhenDrained has the UserKey's devices on this node, which just refused
+// a message because their send queues were full, deliver the offline queue
+// once they catch up instead of waiting for a reconnect
+func (s *Hub) flushWhenDrained(key string) {
+	for _, client := range s.clientsFor(key) {
+		client.flushWhenDrained()
+	}
+}
+
+// deliverLocal sends msg to the devices of the UserKey connected to this node
+func (s *Hub) deliverLocal(key string, msg models.Message, excludeDevice string) bool {
+	delivered := false
+	for _, client := range s.clientsFor(key) {
+		if client.DeviceID == excludeDevice {
+			continue
+		}
+		if client.Send(msg) {
+			delivered = true
 		}
 	}
+	return delivered
 }
 
-// ProcessPersistMessages processes persistence messages from channel
+// ProcessPersistMessages runs the persist workers until the hub's queues are
+// closed. Each worker handles its conversations in order, while a slow
+// conversation or tenant only holds up the others that share its worker.
 func (s *Hub) ProcessPersistMessages() {
-	for msg := range s.persistChan {
-		go services.PersistMessage(msg)
+	var wg sync.WaitGroup
+	for _, queue := range s.persistChans {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.processPersistQueue(queue)
+		}()
+	}
+	wg.Wait()
+}
+
+// processPersistQueue stores and applies the messages of one persist worker
+func (s *Hub) processPersistQueue(queue chan models.Message) {
+	for msg := range queue {
+		switch msg.Type {
+		case "delivered":
+			if err := s.chatService.ForTenant(msg.Tenant).UpdateDeliveryState(msg.MessageID, msg.Type, msg.Timestamp); err != nil {
+				log.Printf("Error recording %s receipt for %s: %v", msg.Type, msg.MessageID, err)
+			}
+		case "read":
+			s.applyRead(msg)
+		case "edit", "delete":
+			s.applyChange(msg)
+		case "reaction_add", "reaction_remove":
+			s.applyReaction(msg)
+		default:
+			s.storeMessage(msg)
+		}
+	}
+}
+
+// ProcessHistoryRequests replays stored messages to users who just
+// registered, skipping any the device was already sent since it connected.
+// Replays wait for room in the send queue rather than drop messages.
+func (s *Hub) ProcessHistoryRequests() {
+	for query := range s.historyChan {
+		client, exists := s.GetClient(query.Tenant, query.UserID, query.DeviceID)
+		if !exists {
+			continue
+		}
+
+		chat := s.chatService.ForTenant(query.Tenant)
+		records, err := chat.GetMessages(query)
+		if err != nil {
+			log.Printf("Error fetching history for %s: %v", query.UserID, err)
+			client.replayFinished()
+			continue
+		}
+
+		messageIDs := make([]string, 0, len(records))
+		for _, record := range records {
+			messageIDs = append(messageIDs, record.MessageID)
+		}
+		reactions, err := chat.GetReactions(messageIDs)
+		if err != nil {
+			log.Printf("Error fetching reactions for %s: %v", query.UserID, err)
+		}
+		attachments, err := chat.GetAttachments(messageIDs)
+		if err != nil {
+			log.Printf("Error fetching attachments for %s: %v", query.UserID, err)
+		}
+
+		replayed := 0
+		var sendErr error
+		for _, record := range records {
+			// Already delivered live or from the offline queue
+			if client.sentBeforeReplay(record.MessageID) {
+				continue
+			}
+			msg := record.ToMessage()
+			msg.Type = "history"
+			msg.Reactions = reactions[record.MessageID]
+			if !msg.Deleted {
+				msg.Attachments = attachments[record.MessageID]
+			}
+			if sendErr = client.SendHistory(msg); sendErr != nil {
+				break
+			}
+			replayed++
+		}
+		client.replayFinished()
+		if sendErr == nil {
+			sendErr = client.SendHistory(models.Message{
+				Text:      strconv.Itoa(replayed),
+				Sender:    "server",
+				Receiver:  query.UserID,
+				Type:      "history_end",
+				Timestamp: time.Now(),
+			})
+		}
+		if sendErr != nil {
+			log.Printf("Error sending history to %s: %v", query.UserID, sendErr)
+		}
 	}
 }
@@ -0,0 +1,310 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/glebarez/sqlite"
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// TestMain sets the configuration the hub reads, which tests don't load
+func TestMain(m *testing.M) {
+	config.AppConfig.NodeID = "test-node"
+	config.AppConfig.HistoryLimit = 50
+	config.AppConfig.OfflineQueueTTL = time.Hour
+	config.AppConfig.OfflineQueueMaxLen = 500
+	config.AppConfig.SendBufferSize = 64
+	config.AppConfig.SlowConsumerPolicy = string(DropOldest)
+	config.AppConfig.WSPingInterval = 30 * time.Second
+	config.AppConfig.WSPongWait = 60 * time.Second
+	config.AppConfig.DuplicateSession = RejectDuplicateSessions
+	config.AppConfig.TypingTimeout = 10 * time.Second
+	config.AppConfig.IdempotencyWindow = 10 * time.Minute
+	config.AppConfig.WSTicketTTL = 30 * time.Second
+	os.Exit(m.Run())
+}
+
+// newTestHub returns a single-node hub backed by a private in-memory
+// database and Redis server, with its chat and persist loops running
+func newTestHub(t *testing.T) *Hub {
+	t.Helper()
+	return newTestNode(t, newTestDB(t), newTestRedis(t), config.AppConfig.NodeID)
+}
+
+// newTestDB opens a private in-memory database with the chat schema
+func newTestDB(t *testing.T) *gorm.DB {
+	t.Helper()
+	dsn := fmt.Sprintf("file:%s?mode=memory&cache=shared", t.Name())
+	db, err := gorm.Open(sqlite.Open(dsn), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		t.Fatalf("opening database: %v", err)
+	}
+	err = db.AutoMigrate(models.All()...)
+	if err != nil {
+		t.Fatalf("migrating: %v", err)
+	}
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	return db
+}
+
+// newTestRedis connects to a private Redis server
+func newTestRedis(t *testing.T) *redis.Client {
+	t.Helper()
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	return rdb
+}
+
+// newTestNode returns a hub for nodeID on db and rdb, with its chat and
+// persist loops running
+func newTestNode(t *testing.T, db *gorm.DB, rdb *redis.Client, nodeID string) *Hub {
+	t.Helper()
+	hub := NewHub(services.NewChatService(db), services.NewRoomService(db), services.NewTenantService(db), rdb)
+	hub.nodeID = nodeID
+	go hub.ProcessChatMessages()
+	go hub.ProcessPersistMessages()
+	return hub
+}
+
+// connect registers a client without a socket, whose frames tests read
+// straight from its send queue
+func connect(hub *Hub, userID, deviceID string) *Client {
+	return connectWithQueue(hub, userID, deviceID, 64, DropOldest)
+}
+
+// connectIn is connect for a user of another tenant
+func connectIn(hub *Hub, tenant, userID, deviceID string) *Client {
+	return connectClient(hub, tenant, userID, deviceID, 64, DropOldest)
+}
+
+// connectWithQueue is connect with a send queue of the given size and policy
+func connectWithQueue(hub *Hub, userID, deviceID string, size int, policy SlowConsumerPolicy) *Client {
+	return connectClient(hub, models.DefaultTenant, userID, deviceID, size, policy)
+}
+
+func connectClient(hub *Hub, tenant, userID, deviceID string, size int, policy SlowConsumerPolicy) *Client {
+	client := &Client{
+		ID:        userID,
+		User:      userID,
+		Tenant:    tenant,
+		DeviceID:  deviceID,
+		hub:       hub,
+		send:      make(chan outbound, size),
+		done:      make(chan struct{}),
+		policy:    policy,
+		sentEarly: make(map[string]struct{}),
+	}
+
+	hub.mutex.Lock()
+	key := UserKey(client.Tenant, userID)
+	if hub.clients[key] == nil {
+		hub.clients[key] = make(map[string]*Client)
+	}
+	hub.clients[key][deviceID] = client
+	hub.connections[client.Tenant]++
+	hub.mutex.Unlock()
+
+	hub.registerPresence(key)
+	return client
+}
+
+// next returns the next frame of type msgType queued for client, skipping others
+func next(t *testing.T, client *Client, msgType string) models.Message {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case out := <-client.send:
+			if out.message.Type == msgType {
+				return out.message
+			}
+		case <-timeout:
+			t.Fatalf("%s got no %s frame", client.ID, msgType)
+		}
+	}
+}
+
+// none fails the test if client is sent a frame of type msgType shortly
+func none(t *testing.T, client *Client, msgType string) {
+	t.Helper()
+	timeout := time.After(100 * time.Millisecond)
+	for {
+		select {
+		case out := <-client.send:
+			if out.message.Type == msgType {
+				t.Fatalf("%s got an unexpected %s frame: %+v", client.ID, msgType, out.message)
+			}
+		case <-timeout:
+			return
+		}
+	}
+}
+
+func TestReplyRightAfterParentIsAccepted(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	// Submit the reply before the parent can possibly have been stored
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "lunch?", Type: "chat", DeviceID: "phone"})
+	parentID := next(t, alice, "ack").MessageID
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "or dinner", Type: "chat", DeviceID: "phone", ParentID: parentID})
+
+	if got := next(t, bob, "chat"); got.ID != parentID {
+		t.Fatalf("first delivery = %s, want the parent %s", got.ID, parentID)
+	}
+	reply := next(t, bob, "chat")
+	if reply.ParentID != parentID || reply.Quote == nil || reply.Quote.Text != "lunch?" {
+		t.Errorf("reply = %+v, want it quoting the parent", reply)
+	}
+}
+
+func TestMessagesAreStoredBeforeDelivery(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+	delivered := next(t, bob, "chat")
+
+	if _, err := hub.chatService.GetMessage(delivered.ID); err != nil {
+		t.Errorf("delivered message isn't stored: %v", err)
+	}
+}
+
+func TestReplyToOtherConversationIsRefused(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	connect(hub, "bob", "laptop")
+	carol := connect(hub, "carol", "tablet")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "secret", Type: "chat", DeviceID: "phone"})
+	parentID := next(t, alice, "ack").MessageID
+
+	hub.Submit(carol, models.Message{Sender: "carol", Receiver: "alice", Text: "what secret?", Type: "chat", DeviceID: "tablet", ParentID: parentID})
+	if got := next(t, carol, "message_error"); got.Text != "Parent message is not in this conversation" {
+		t.Errorf("error = %q", got.Text)
+	}
+}
+
+func TestRateLimitedMessageCanBeRetried(t *testing.T) {
+	hub := newTestHub(t)
+	if _, err := hub.tenantService.SaveTenant(models.Tenant{ID: models.DefaultTenant, Name: "Default", MessagesPerMinute: 1}); err != nil {
+		t.Fatalf("SaveTenant: %v", err)
+	}
+	alice := connect(hub, "alice", "phone")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "one", Type: "chat", DeviceID: "phone"})
+	next(t, alice, "ack")
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "two", Type: "chat", DeviceID: "phone", IdempotencyKey: "k2"})
+	next(t, alice, "message_error")
+
+	// Once the limit resets the retry must be stored, not acknowledged as a duplicate
+	if _, err := hub.tenantService.SaveTenant(models.Tenant{ID: models.DefaultTenant, Name: "Default"}); err != nil {
+		t.Fatalf("SaveTenant: %v", err)
+	}
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "two", Type: "chat", DeviceID: "phone", IdempotencyKey: "k2"})
+	ack := next(t, alice, "ack")
+	if _, err := hub.chatService.GetMessage(ack.MessageID); err != nil {
+		t.Errorf("acknowledged retry isn't stored: %v", err)
+	}
+}
+
+func TestRetryWithSameKeyIsDeliveredOnce(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	send := models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone", IdempotencyKey: "k1"}
+	hub.Submit(alice, send)
+	first := next(t, alice, "ack")
+	hub.Submit(alice, send)
+	retry := next(t, alice, "ack")
+
+	if retry.MessageID != first.MessageID || retry.IdempotencyKey != "k1" {
+		t.Errorf("retry acknowledged as %+v, want the original %s", retry, first.MessageID)
+	}
+	next(t, bob, "chat")
+	none(t, bob, "chat")
+
+	stored, err := hub.chatService.ForTenant(models.DefaultTenant).GetMessages(models.QueryMessage{UserID: "bob", Limit: 10})
+	if err != nil {
+		t.Fatalf("GetMessages: %v", err)
+	}
+	if len(stored) != 1 {
+		t.Errorf("stored %d messages, want 1", len(stored))
+	}
+}
+
+func TestIdempotencyKeysArePerSender(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	carol := connect(hub, "carol", "tablet")
+	bob := connect(hub, "bob", "laptop")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "from alice", Type: "chat", DeviceID: "phone", IdempotencyKey: "k1"})
+	hub.Submit(carol, models.Message{Sender: "carol", Receiver: "bob", Text: "from carol", Type: "chat", DeviceID: "tablet", IdempotencyKey: "k1"})
+
+	if next(t, alice, "ack").MessageID == next(t, carol, "ack").MessageID {
+		t.Error("different senders' messages were deduplicated")
+	}
+	next(t, bob, "chat")
+	next(t, bob, "chat")
+}
+
+func TestRefusedMessageCanBeRetriedWithSameKey(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "re", Type: "chat", DeviceID: "phone", IdempotencyKey: "k1", ParentID: "missing"})
+	next(t, alice, "message_error")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "re", Type: "chat", DeviceID: "phone", IdempotencyKey: "k1"})
+	ack := next(t, alice, "ack")
+	if got := next(t, bob, "chat"); got.ID != ack.MessageID {
+		t.Errorf("bob got %s, want the corrected retry %s", got.ID, ack.MessageID)
+	}
+}
+
+func TestConversationSharesPersistQueue(t *testing.T) {
+	hub := newTestHub(t)
+	tests := []struct {
+		name string
+		a, b models.Message
+	}{
+		{
+			"either direction",
+			models.Message{Tenant: "acme", Sender: "alice", Receiver: "bob", Type: "chat"},
+			models.Message{Tenant: "acme", Sender: "bob", Receiver: "alice", Type: "chat", ParentID: "m1"},
+		},
+		{
+			"room",
+			models.Message{Tenant: "acme", Sender: "alice", RoomID: "r1", Type: "room"},
+			models.Message{Tenant: "acme", Sender: "carol", RoomID: "r1", Type: "room"},
+		},
+		{
+			"changes to a message",
+			models.Message{Tenant: "acme", Sender: "bob", MessageID: "m1", Type: "read"},
+			models.Message{Tenant: "acme", Sender: "alice", MessageID: "m1", Type: "delete"},
+		},
+	}
+	for _, tt := range tests {
+		if hub.persistQueue(tt.a) != hub.persistQueue(tt.b) {
+			t.Errorf("%s: messages went to different persist queues", tt.name)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const offlineQueuePrefix = "chat:inbox:"
+
+// offlineQueueKey returns the queue for a UserKey
+func offlineQueueKey(userKey string) string {
+	return offlineQueuePrefix + userKey
+}
+
+// queueOfflineMessage stores a message for a user that is not connected,
+// identified by their UserKey. The queue is capped at OfflineQueueMaxLen
+// (oldest entries are dropped) and expires OfflineQueueTTL after the last
+// queued message.
+func (s *Hub) queueOfflineMessage(userKey string, msg models.Message) {
+	if s.rdb == nil {
+		return
+	}
+
+	payload, err := json.Marshal(msg)
+	if err != nil {
+		log.Printf("Error encoding offline message for %s: %v", userKey, err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	key := offlineQueueKey(userKey)
+	_, err = s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.RPush(ctx, key, payload)
+		pipe.LTrim(ctx, key, int64(-config.AppConfig.OfflineQueueMaxLen), -1)
+		pipe.Expire(ctx, key, config.AppConfig.OfflineQueueTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error queueing offline message for %s: %v", userKey, err)
+	}
+}
+
+// FlushOfflineMessages delivers, in order, every message queued for
+// client's user while none of their devices could take it, and clears the
+// queue. Messages go to every device of the user connected at the time, on
+// any node, so a second device connecting at once doesn't miss them. Devices
+// that connect later see them in their history replay.
+func (s *Hub) FlushOfflineMessages(client *Client) {
+	if s.rdb == nil {
+		return
+	}
+
+	userKey := UserKey(client.Tenant, client.ID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Read and clear atomically so a message is never delivered twice
+	key := offlineQueueKey(userKey)
+	var pending *redis.StringSliceCmd
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pending = pipe.LRange(ctx, key, 0, -1)
+		pipe.Del(ctx, key)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error reading offline messages for %s: %v", userKey, err)
+		return
+	}
+
+	for i, payload := range pending.Val() {
+		var msg models.Message
+		if err := json.Unmarshal([]byte(payload), &msg); err != nil {
+			log.Printf("Error decoding offline message for %s: %v", userKey, err)
+			continue
+		}
+		if !s.deliverToUser(client.Tenant, client.ID, msg, "", false) {
+			log.Printf("Error delivering offline messages to %s: no device took them", userKey)
+			s.requeueOfflineMessages(userKey, pending.Val()[i:])
+			// Busy connections try the rest again once they catch up
+			s.flushWhenDrained(userKey)
+			return
+		}
+	}
+}
+
+// requeueOfflineMessages puts undelivered payloads back at the head of the queue
+func (s *Hub) requeueOfflineMessages(userKey string, payloads []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	values := make([]interface{}, 0, len(payloads))
+	for i := len(payloads) - 1; i >= 0; i-- {
+		values = append(values, payloads[i])
+	}
+	key := offlineQueueKey(userKey)
+	_, err := s.rdb.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.LPush(ctx, key, values...)
+		pipe.Expire(ctx, key, config.AppConfig.OfflineQueueTTL)
+		return nil
+	})
+	if err != nil {
+		log.Printf("Error requeueing offline messages for %s: %v", userKey, err)
+	}
+}
@@ -0,0 +1,138 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"context"
+	"testing"
+	"time"
+)
+
+// waitForOfflineQueue waits until length messages are queued for userID
+func waitForOfflineQueue(t *testing.T, hub *Hub, userID string, length int64) {
+	t.Helper()
+	key := offlineQueueKey(UserKey(models.DefaultTenant, userID))
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.rdb.LLen(context.Background(), key).Val() != length {
+		if time.Now().After(deadline) {
+			t.Fatalf("%s's offline queue never reached %d messages", userID, length)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBusyClientGetsRefusedMessagesOnceDrained(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connectWithQueue(hub, "bob", "laptop", 1, DropNewest)
+
+	// Fill bob's queue so the next message is refused
+	bob.Send(models.Message{Type: "presence", Sender: "server", Timestamp: time.Now()})
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+	messageID := next(t, alice, "ack").MessageID
+
+	// Wait for the refusal to reach the offline queue
+	deadline := time.Now().Add(2 * time.Second)
+	for !bob.backlogged.Load() {
+		if time.Now().After(deadline) {
+			t.Fatal("bob never refused the message")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	// The write pump takes the queued frame and finds the queue empty
+	<-bob.send
+	bob.drained()
+
+	if got := next(t, bob, "chat"); got.ID != messageID {
+		t.Errorf("bob got %s after draining, want %s", got.ID, messageID)
+	}
+}
+
+func TestOfflineFlushReachesEveryConnectedDevice(t *testing.T) {
+	hub := newTestHub(t)
+	key := UserKey(models.DefaultTenant, "bob")
+	for _, id := range []string{"m1", "m2"} {
+		hub.queueOfflineMessage(key, models.Message{ID: id, Sender: "alice", Receiver: "bob", Type: "chat", Timestamp: time.Now()})
+	}
+
+	laptop := connect(hub, "bob", "laptop")
+	phone := connect(hub, "bob", "phone")
+	hub.FlushOfflineMessages(laptop)
+
+	for _, device := range []*Client{laptop, phone} {
+		for _, want := range []string{"m1", "m2"} {
+			if got := next(t, device, "chat"); got.ID != want {
+				t.Errorf("%s got %s, want %s", device.DeviceID, got.ID, want)
+			}
+		}
+	}
+
+	// The queue is cleared, so a later flush delivers nothing again
+	hub.FlushOfflineMessages(phone)
+	select {
+	case out := <-phone.send:
+		t.Errorf("second flush delivered %+v", out.message)
+	default:
+	}
+}
+
+func TestHistoryReplaySkipsMessagesAlreadySent(t *testing.T) {
+	hub := newTestHub(t)
+	go hub.ProcessHistoryRequests()
+	alice := connect(hub, "alice", "phone")
+
+	// Bob is offline for the first message and connects before the second
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "one", Type: "chat", DeviceID: "phone"})
+	first := next(t, alice, "ack").MessageID
+	waitForOfflineQueue(t, hub, "bob", 1)
+	bob := connect(hub, "bob", "laptop")
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "two", Type: "chat", DeviceID: "phone"})
+	second := next(t, alice, "ack").MessageID
+
+	if got := next(t, bob, "chat"); got.ID != second {
+		t.Fatalf("live delivery = %s, want %s", got.ID, second)
+	}
+	hub.FlushOfflineMessages(bob)
+	if got := next(t, bob, "chat"); got.ID != first {
+		t.Fatalf("offline delivery = %s, want %s", got.ID, first)
+	}
+
+	hub.FetchHistoricalMessages(models.DefaultTenant, "bob", "laptop")
+	if end := next(t, bob, "history_end"); end.Text != "0" {
+		t.Errorf("history replayed %s messages bob already had", end.Text)
+	}
+
+	// Later connections aren't tracked
+	if bob.sentBeforeReplay(first) {
+		t.Error("still tracking sent messages after the replay")
+	}
+}
+
+func TestHistoryReplayWaitsForRoomInsteadOfDropping(t *testing.T) {
+	hub := newTestHub(t)
+	go hub.ProcessHistoryRequests()
+	chat := hub.chatService.ForTenant(models.DefaultTenant)
+	for _, text := range []string{"one", "two", "three"} {
+		if err := chat.SaveMessage(models.Message{Sender: "alice", Receiver: "bob", Text: text, Type: "chat"}); err != nil {
+			t.Fatalf("SaveMessage: %v", err)
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	bob := connectWithQueue(hub, "bob", "laptop", 1, DropNewest)
+	hub.FetchHistoricalMessages(models.DefaultTenant, "bob", "laptop")
+	// Let the replay fill the queue before reading anything
+	time.Sleep(50 * time.Millisecond)
+
+	for _, want := range []string{"one", "two", "three"} {
+		if got := next(t, bob, "history"); got.Text != want {
+			t.Errorf("history = %q, want %q", got.Text, want)
+		}
+	}
+	if end := next(t, bob, "history_end"); end.Text != "3" {
+		t.Errorf("history_end = %s, want 3", end.Text)
+	}
+	if dropped := bob.Stats().Dropped; dropped != 0 {
+		t.Errorf("dropped %d frames", dropped)
+	}
+}
@@ -0,0 +1,67 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"reflect"
+	"testing"
+)
+
+func TestReactionsReachConversation(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	msg := sendChat(t, hub, alice, bob, "lunch?")
+
+	steps := []struct {
+		client *Client
+		change string
+		want   []models.ReactionSummary
+	}{
+		{bob, "reaction_add", []models.ReactionSummary{{Emoji: "👍", Count: 1, Users: []string{"bob"}}}},
+		// Reacting twice with the same emoji counts once
+		{bob, "reaction_add", []models.ReactionSummary{{Emoji: "👍", Count: 1, Users: []string{"bob"}}}},
+		{alice, "reaction_add", []models.ReactionSummary{{Emoji: "👍", Count: 2, Users: []string{"bob", "alice"}}}},
+		{bob, "reaction_remove", []models.ReactionSummary{{Emoji: "👍", Count: 1, Users: []string{"alice"}}}},
+	}
+	for i, step := range steps {
+		hub.RequestChange(step.client, models.Message{Type: step.change, MessageID: msg.ID, Emoji: "👍"})
+		for _, client := range []*Client{alice, bob} {
+			got := next(t, client, step.change)
+			if got.MessageID != msg.ID || got.Sender != step.client.ID || !reflect.DeepEqual(got.Reactions, step.want) {
+				t.Errorf("step %d: %s got %+v, want reactions %+v", i, client.ID, got, step.want)
+			}
+		}
+	}
+}
+
+func TestOnlyParticipantsCanReact(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	carol := connect(hub, "carol", "tablet")
+	msg := sendChat(t, hub, alice, bob, "secret")
+
+	hub.RequestChange(carol, models.Message{Type: "reaction_add", MessageID: msg.ID, Emoji: "👀"})
+	if got := next(t, carol, "message_error"); got.Text != "Not a participant in this conversation" {
+		t.Errorf("error = %q", got.Text)
+	}
+	none(t, alice, "reaction_add")
+}
+
+func TestHistoryCarriesReactions(t *testing.T) {
+	hub := newTestHub(t)
+	go hub.ProcessHistoryRequests()
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	msg := sendChat(t, hub, alice, bob, "lunch?")
+	hub.RequestChange(bob, models.Message{Type: "reaction_add", MessageID: msg.ID, Emoji: "🍕"})
+	next(t, bob, "reaction_add")
+
+	tablet := connect(hub, "bob", "tablet")
+	hub.FetchHistoricalMessages(models.DefaultTenant, "bob", "tablet")
+	got := next(t, tablet, "history")
+	want := []models.ReactionSummary{{Emoji: "🍕", Count: 1, Users: []string{"bob"}}}
+	if got.ID != msg.ID || !reflect.DeepEqual(got.Reactions, want) {
+		t.Errorf("history got %+v, want reactions %+v", got, want)
+	}
+}
@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"context"
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	deliveredPrefix = "chat:delivered:"
+	// How long a delivered receipt is remembered so other devices don't repeat it
+	deliveredTTL = 24 * time.Hour
+)
+
+// messageDelivered is called by a receiver's write pump once a direct chat
+// message has been written to the socket. The first device to receive the
+// message triggers a delivered receipt to the sender. Receipts are only sent
+// to the sender's connected devices; offline senders see the state in their
+// history.
+func (s *Hub) messageDelivered(msg models.Message) {
+	if s.rdb != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		first, err := s.rdb.SetNX(ctx, deliveredPrefix+msg.ID, 1, deliveredTTL).Result()
+		cancel()
+		if err != nil {
+			log.Printf("Error recording delivery of %s: %v", msg.ID, err)
+		} else if !first {
+			return
+		}
+	}
+
+	receipt := models.Message{
+		Sender:    msg.Receiver,
+		Receiver:  msg.Sender,
+		Tenant:    msg.Tenant,
+		Type:      "delivered",
+		MessageID: msg.ID,
+		Timestamp: time.Now(),
+	}
+	s.SendToPersist(receipt)
+	s.deliverToUser(msg.Tenant, msg.Sender, receipt, "", false)
+}
+
+// MarkRead queues a read receipt from client for a message it received.
+// Receipts go through the persist queue of the message they refer to, so
+// they are recorded in order with its other receipts and changes.
+func (s *Hub) MarkRead(client *Client, messageID string) {
+	s.SendToPersist(models.Message{
+		Sender:    client.ID,
+		Tenant:    client.Tenant,
+		Type:      "read",
+		MessageID: messageID,
+		DeviceID:  client.DeviceID,
+		Timestamp: time.Now(),
+	})
+}
+
+// applyRead records a read receipt and relays it to the message's
+// connected sender and the reader's other devices. Only the message's
+// receiver may mark it read. It runs on a persist worker.
+func (s *Hub) applyRead(receipt models.Message) {
+	chat := s.chatService.ForTenant(receipt.Tenant)
+	record, err := chat.GetMessage(receipt.MessageID)
+	if err == nil && record.Receiver != receipt.Sender {
+		err = services.ErrNotParticipant
+	}
+	if err != nil {
+		text := "Message not found"
+		switch {
+		case errors.Is(err, services.ErrNotParticipant):
+			text = "Only the receiver can mark a message read"
+		case !errors.Is(err, services.ErrMessageNotFound):
+			log.Printf("Error loading message %s: %v", receipt.MessageID, err)
+		}
+		if client, exists := s.GetClient(receipt.Tenant, receipt.Sender, receipt.DeviceID); exists {
+			s.sendReceiptError(client, receipt.MessageID, text)
+		}
+		return
+	}
+
+	if err := chat.UpdateDeliveryState(receipt.MessageID, receipt.Type, receipt.Timestamp); err != nil {
+		log.Printf("Error recording read receipt for %s: %v", receipt.MessageID, err)
+	}
+	receipt.Receiver = record.Sender
+	s.deliverToUser(receipt.Tenant, record.Sender, receipt, "", false)
+	// Let the reader's other devices clear their unread state too
+	s.deliverToUser(receipt.Tenant, receipt.Sender, receipt, receipt.DeviceID, false)
+}
+
+func (s *Hub) sendReceiptError(client *Client, messageID, text string) {
+	client.Send(models.Message{
+		Text:      text,
+		Sender:    "server",
+		Receiver:  client.ID,
+		Type:      "receipt_error",
+		MessageID: messageID,
+		Timestamp: time.Now(),
+	})
+}
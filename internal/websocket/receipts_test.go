@@ -0,0 +1,114 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestReadReceiptRelayedToSender(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	bob := connect(hub, "bob", "laptop")
+	bobPhone := connect(hub, "bob", "phone")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+	msg := next(t, bob, "chat")
+	hub.MarkRead(bob, msg.ID)
+
+	if got := next(t, alice, "read"); got.MessageID != msg.ID || got.Sender != "bob" {
+		t.Errorf("sender got receipt %+v", got)
+	}
+	if got := next(t, bobPhone, "read"); got.MessageID != msg.ID {
+		t.Errorf("reader's other device got receipt %+v", got)
+	}
+
+	record, err := hub.chatService.GetMessage(msg.ID)
+	if err != nil {
+		t.Fatalf("GetMessage: %v", err)
+	}
+	if record.ToMessage().Status != models.DeliveryRead {
+		t.Errorf("status = %q, want %q", record.ToMessage().Status, models.DeliveryRead)
+	}
+}
+
+func TestReadReceiptOnlyFromReceiver(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connect(hub, "alice", "phone")
+	connect(hub, "bob", "laptop")
+	carol := connect(hub, "carol", "tablet")
+
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+	messageID := next(t, alice, "ack").MessageID
+
+	hub.MarkRead(carol, messageID)
+	if got := next(t, carol, "receipt_error"); got.Text != "Only the receiver can mark a message read" {
+		t.Errorf("error = %q", got.Text)
+	}
+	hub.MarkRead(carol, "missing")
+	if got := next(t, carol, "receipt_error"); got.Text != "Message not found" {
+		t.Errorf("error = %q", got.Text)
+	}
+}
+
+func TestDeliveredReceiptForReplayedMessage(t *testing.T) {
+	hub := newTestHub(t)
+	go hub.ProcessHistoryRequests()
+	alice := connect(hub, "alice", "phone")
+	// Stored while bob was away and his offline queue expired
+	msg := models.Message{ID: "m1", Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", Timestamp: time.Now()}
+	if err := hub.chatService.SaveMessage(msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	url, clients := serve(t, hub, "bob")
+	dial(t, url)
+	bob := accepted(t, clients)
+	if err := hub.RegisterUser("bob", "laptop", bob); err != nil {
+		t.Fatalf("RegisterUser: %v", err)
+	}
+	hub.FetchHistoricalMessages(models.DefaultTenant, "bob", "laptop")
+
+	if got := next(t, alice, "delivered"); got.MessageID != msg.ID || got.Sender != "bob" {
+		t.Errorf("sender got receipt %+v", got)
+	}
+}
+
+func TestIsDirectDelivery(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  models.Message
+		want bool
+	}{
+		{"live", models.Message{ID: "m1", Sender: "alice", Receiver: "bob", Type: "chat"}, true},
+		{"sender's other device", models.Message{ID: "m1", Sender: "bob", Receiver: "alice", Type: "chat"}, false},
+		{"room", models.Message{ID: "m1", Sender: "alice", Receiver: "bob", RoomID: "r1", Type: "chat"}, false},
+		{"replayed", models.Message{ID: "m1", Sender: "alice", Receiver: "bob", Type: "history", Status: models.DeliveryPending}, true},
+		{"replayed again", models.Message{ID: "m1", Sender: "alice", Receiver: "bob", Type: "history", Status: models.DeliveryDelivered}, false},
+	}
+	for _, tt := range tests {
+		if got := isDirectDelivery(tt.msg, "bob"); got != tt.want {
+			t.Errorf("%s: isDirectDelivery = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReceiptsAreNotQueuedForOfflineSender(t *testing.T) {
+	hub := newTestHub(t)
+	bob := connect(hub, "bob", "laptop")
+	bobPhone := connect(hub, "bob", "phone")
+	msg := models.Message{ID: "m1", Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", Timestamp: time.Now()}
+	if err := hub.chatService.SaveMessage(msg); err != nil {
+		t.Fatalf("SaveMessage: %v", err)
+	}
+
+	hub.messageDelivered(msg)
+	hub.MarkRead(bob, msg.ID)
+	next(t, bobPhone, "read")
+
+	key := offlineQueueKey(UserKey(models.DefaultTenant, "alice"))
+	if queued := hub.rdb.LLen(context.Background(), key).Val(); queued != 0 {
+		t.Errorf("queued %d receipts for alice", queued)
+	}
+}
@@ -0,0 +1,171 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"chatsystem/internal/services"
+	"errors"
+	"log"
+	"time"
+)
+
+// HandleRoomFrame processes room management frames and room messages
+// from a registered client. Membership is persisted by the room service,
+// so rooms survive restarts and are shared by every node. Clients only see
+// rooms of their own tenant. Anyone may join an open room, closed rooms
+// only take members invited by their creator.
+func (s *Hub) HandleRoomFrame(client *Client, msg models.Message) {
+	if client.ID == "" {
+		s.sendRoomError(client, msg.RoomID, "Register before using rooms")
+		return
+	}
+	if msg.Type != "room_create" && msg.RoomID == "" {
+		s.sendRoomError(client, "", "room_id is required")
+		return
+	}
+
+	// The connection's identity is authoritative, not the frame's
+	msg.Sender = client.ID
+	msg.Tenant = client.Tenant
+	rooms := s.roomService.ForTenant(client.Tenant)
+
+	switch msg.Type {
+	case "room_create":
+		if msg.Text == "" {
+			s.sendRoomError(client, "", "Room name is required")
+			return
+		}
+		room, err := rooms.CreateRoom(msg.Text, client.ID, msg.Open)
+		if err != nil {
+			log.Printf("Error creating room for %s: %v", client.ID, err)
+			s.sendRoomError(client, "", "Could not create room")
+			return
+		}
+		s.deliverToUser(client.Tenant, client.ID, roomEvent("room_created", room.ID, client.ID, room.Name, []string{client.ID}), "", false)
+
+	case "room_join":
+		if err := rooms.JoinRoom(msg.RoomID, client.ID); err != nil {
+			switch {
+			case errors.Is(err, services.ErrRoomNotFound):
+				s.sendRoomError(client, msg.RoomID, "Room not found")
+			case errors.Is(err, services.ErrRoomClosed):
+				s.sendRoomError(client, msg.RoomID, "Room is invite only")
+			default:
+				log.Printf("Error joining room %s for %s: %v", msg.RoomID, client.ID, err)
+				s.sendRoomError(client, msg.RoomID, "Could not join room")
+			}
+			return
+		}
+		s.notifyRoom(client.Tenant, msg.RoomID, roomEvent("room_joined", msg.RoomID, client.ID, "", nil), nil)
+
+	case "room_invite":
+		if len(msg.Members) == 0 {
+			s.sendRoomError(client, msg.RoomID, "members is required")
+			return
+		}
+		if err := rooms.InviteMembers(msg.RoomID, client.ID, msg.Members); err != nil {
+			switch {
+			case errors.Is(err, services.ErrRoomNotFound):
+				s.sendRoomError(client, msg.RoomID, "Room not found")
+			case errors.Is(err, services.ErrNotRoomOwner):
+				s.sendRoomError(client, msg.RoomID, "Only the room's creator can invite members")
+			default:
+				log.Printf("Error inviting members to room %s for %s: %v", msg.RoomID, client.ID, err)
+				s.sendRoomError(client, msg.RoomID, "Could not invite members")
+			}
+			return
+		}
+		s.notifyRoom(client.Tenant, msg.RoomID, roomEvent("room_joined", msg.RoomID, client.ID, "", msg.Members), nil)
+
+	case "room_leave":
+		if !s.requireRoomMember(client, msg.RoomID) {
+			return
+		}
+		if err := rooms.LeaveRoom(msg.RoomID, client.ID); err != nil {
+			log.Printf("Error leaving room %s for %s: %v", msg.RoomID, client.ID, err)
+			s.sendRoomError(client, msg.RoomID, "Could not leave room")
+			return
+		}
+		// The leaver is no longer a member, so tell them directly
+		s.notifyRoom(client.Tenant, msg.RoomID, roomEvent("room_left", msg.RoomID, client.ID, "", nil), []string{client.ID})
+
+	case "room_members":
+		if !s.requireRoomMember(client, msg.RoomID) {
+			return
+		}
+		members, err := rooms.GetMembers(msg.RoomID)
+		if err != nil {
+			log.Printf("Error listing members of room %s: %v", msg.RoomID, err)
+			s.sendRoomError(client, msg.RoomID, "Could not list members")
+			return
+		}
+		client.Send(roomEvent("room_members", msg.RoomID, "server", "", members))
+
+	case "room":
+		if !s.requireRoomMember(client, msg.RoomID) {
+			return
+		}
+		msg.Receiver = ""
+		s.Submit(client, msg)
+	}
+}
+
+// deliverToRoom fans a room message out to every connected member. Members
+// that are offline see it in their history replay instead.
+func (s *Hub) deliverToRoom(msg models.Message) {
+	members, err := s.roomService.ForTenant(msg.Tenant).GetMembers(msg.RoomID)
+	if err != nil {
+		log.Printf("Error loading members of room %s: %v", msg.RoomID, err)
+		return
+	}
+
+	for _, member := range members {
+		exclude := ""
+		if member == msg.Sender {
+			exclude = msg.DeviceID
+		}
+		s.deliverToUser(msg.Tenant, member, msg, exclude, false)
+	}
+}
+
+// notifyRoom sends an event to every member of a tenant's room plus any extra users
+func (s *Hub) notifyRoom(tenant, roomID string, event models.Message, extra []string) {
+	members, err := s.roomService.ForTenant(tenant).GetMembers(roomID)
+	if err != nil {
+		log.Printf("Error loading members of room %s: %v", roomID, err)
+		return
+	}
+
+	for _, member := range append(members, extra...) {
+		s.deliverToUser(tenant, member, event, "", false)
+	}
+}
+
+// requireRoomMember checks membership, replying with an error when it fails
+func (s *Hub) requireRoomMember(client *Client, roomID string) bool {
+	member, err := s.roomService.ForTenant(client.Tenant).IsMember(roomID, client.ID)
+	if err != nil {
+		log.Printf("Error checking membership of room %s for %s: %v", roomID, client.ID, err)
+		s.sendRoomError(client, roomID, "Could not check room membership")
+		return false
+	}
+	if !member {
+		s.sendRoomError(client, roomID, "Not a member of this room")
+		return false
+	}
+	return true
+}
+
+func (s *Hub) sendRoomError(client *Client, roomID, text string) {
+	client.Send(roomEvent("room_error", roomID, "server", text, nil))
+}
+
+func roomEvent(eventType, roomID, sender, text string, members []string) models.Message {
+	return models.Message{
+		Text:      text,
+		Sender:    sender,
+		Type:      eventType,
+		RoomID:    roomID,
+		Members:   members,
+		Timestamp: time.Now(),
+	}
+}
@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"context"
+	"log"
+	"maps"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	rendezvous "github.com/dgryski/go-rendezvous"
+	"github.com/redis/go-redis/v9"
+)
+
+// nodeAddrsKey maps node IDs to the /ws/server URL clients should use to reach them
+const nodeAddrsKey = "chat:node_addrs"
+
+// Router assigns every user to an owning node with rendezvous hashing over
+// the live members of the cluster. Users are identified by their UserKey.
+// Only nodes that advertise a URL take part, so a node without
+// NODE_ADVERTISE_URL accepts every connection.
+type Router struct {
+	nodeID      string
+	advertise   string
+	rdb         *redis.Client
+	mu          sync.RWMutex
+	ring        *rendezvous.Rendezvous
+	members     []string
+	addrs       map[string]string
+	onRebalance func()
+}
+
+// NewRouter creates a router for this node
+func NewRouter(nodeID, advertise string, rdb *redis.Client) *Router {
+	return &Router{
+		nodeID:    nodeID,
+		advertise: advertise,
+		rdb:       rdb,
+		ring:      rendezvous.New(nil, xxhash.Sum64String),
+		addrs:     make(map[string]string),
+	}
+}
+
+// Enabled reports whether this node takes part in user-to-node routing
+func (r *Router) Enabled() bool {
+	return r != nil && r.rdb != nil && r.advertise != ""
+}
+
+// Owner returns the node that owns userID and the URL to reach it
+func (r *Router) Owner(userID string) (string, string) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.members) == 0 {
+		return r.nodeID, r.advertise
+	}
+	node := r.ring.Lookup(userID)
+	return node, r.addrs[node]
+}
+
+// IsLocal reports whether userID should be connected to this node
+func (r *Router) IsLocal(userID string) bool {
+	if !r.Enabled() {
+		return true
+	}
+	node, _ := r.Owner(userID)
+	return node == r.nodeID
+}
+
+// Run advertises this node and refreshes the membership until the process exits
+func (r *Router) Run() {
+	if !r.Enabled() {
+		return
+	}
+
+	ticker := time.NewTicker(nodeHeartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		r.refresh()
+		<-ticker.C
+	}
+}
+
+// refresh reloads the live members and their URLs, and rebuilds the ring
+// when the members change
+func (r *Router) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := r.rdb.HSet(ctx, nodeAddrsKey, r.nodeID, r.advertise).Err(); err != nil {
+		log.Printf("Error advertising node %s: %v", r.nodeID, err)
+		return
+	}
+
+	cutoff := strconv.FormatInt(time.Now().Add(-nodeTTL).Unix(), 10)
+	live, err := r.rdb.ZRangeByScore(ctx, nodesKey, &redis.ZRangeBy{Min: cutoff, Max: "+inf"}).Result()
+	if err != nil {
+		log.Printf("Error listing cluster nodes: %v", err)
+		return
+	}
+	addrs, err := r.rdb.HGetAll(ctx, nodeAddrsKey).Result()
+	if err != nil {
+		log.Printf("Error loading node addresses: %v", err)
+		return
+	}
+
+	// Always count ourselves in, even before our first heartbeat lands
+	members := []string{r.nodeID}
+	memberAddrs := map[string]string{r.nodeID: r.advertise}
+	for _, node := range live {
+		if node != r.nodeID && addrs[node] != "" {
+			members = append(members, node)
+			memberAddrs[node] = addrs[node]
+		}
+	}
+	sort.Strings(members)
+
+	r.mu.Lock()
+	changed := !equalMembers(r.members, members)
+	// A node that restarts with a new URL keeps its ID, so addresses are
+	// refreshed even when the membership is the same
+	moved := !maps.Equal(r.addrs, memberAddrs)
+	r.addrs = memberAddrs
+	if changed {
+		r.members = members
+		r.ring = rendezvous.New(members, xxhash.Sum64String)
+	}
+	onRebalance := r.onRebalance
+	r.mu.Unlock()
+
+	if moved && !changed {
+		log.Printf("Cluster node addresses changed: %v", memberAddrs)
+	}
+	if changed {
+		log.Printf("Cluster membership changed: %v", members)
+		if onRebalance != nil {
+			onRebalance()
+		}
+	}
+}
+
+func equalMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// rebalance hands off local users that another node now owns
+func (s *Hub) rebalance() {
+	s.mutex.RLock()
+	var moved []string
+	for key := range s.clients {
+		if !s.router.IsLocal(key) {
+			moved = append(moved, key)
+		}
+	}
+	s.mutex.RUnlock()
+
+	for _, key := range moved {
+		_, addr := s.router.Owner(key)
+		for _, client := range s.clientsFor(key) {
+			s.UnregisterClient(client)
+			client.Redirect(addr)
+		}
+	}
+}
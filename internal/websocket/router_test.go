@@ -0,0 +1,54 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRouterPicksUpNewNodeURL(t *testing.T) {
+	rdb := redis.NewClient(&redis.Options{Addr: miniredis.RunT(t).Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	ctx := context.Background()
+
+	advertise := func(node, url string) {
+		t.Helper()
+		rdb.ZAdd(ctx, nodesKey, redis.Z{Score: float64(time.Now().Unix()), Member: node})
+		if err := rdb.HSet(ctx, nodeAddrsKey, node, url).Err(); err != nil {
+			t.Fatalf("advertising %s: %v", node, err)
+		}
+	}
+
+	router := NewRouter("node-a", "ws://a:5100/ws/server", rdb)
+	rebalances := 0
+	router.onRebalance = func() { rebalances++ }
+
+	advertise("node-b", "ws://b-old:5100/ws/server")
+	router.refresh()
+
+	// Find a user owned by the other node
+	user := ""
+	for i := 0; i < 100 && user == ""; i++ {
+		if node, _ := router.Owner(fmt.Sprintf("default:user%d", i)); node == "node-b" {
+			user = fmt.Sprintf("default:user%d", i)
+		}
+	}
+	if user == "" {
+		t.Fatal("node-b owns none of 100 users")
+	}
+
+	// node-b restarts under the same ID with a new URL
+	advertise("node-b", "ws://b-new:5100/ws/server")
+	router.refresh()
+
+	if node, url := router.Owner(user); node != "node-b" || url != "ws://b-new:5100/ws/server" {
+		t.Errorf("Owner(%s) = %s, %s, want node-b at its new URL", user, node, url)
+	}
+	if rebalances != 1 {
+		t.Errorf("rebalanced %d times, want only for the membership change", rebalances)
+	}
+}
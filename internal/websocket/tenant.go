@@ -0,0 +1,103 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"context"
+	"errors"
+	"log"
+	"strconv"
+	"time"
+)
+
+// rateLimitPrefix counts each tenant's messages per minute across the cluster
+const rateLimitPrefix = "chat:rate:"
+
+var (
+	ErrDuplicateSession = errors.New("device already connected")
+	ErrTenantFull       = errors.New("tenant connection limit reached")
+	ErrTenantDisabled   = errors.New("tenant disabled")
+)
+
+// UserKey identifies a user within a tenant. User IDs are only unique inside
+// their tenant, so connections, presence and queues are all keyed by this.
+// Tenant IDs can't contain ':', so keys from different tenants never collide.
+func UserKey(tenant, userID string) string {
+	return tenant + ":" + userID
+}
+
+// tenantSettings loads a tenant's settings. Callers fall back to the
+// server-wide configuration when it can't be loaded.
+func (s *Hub) tenantSettings(tenant string) *models.Tenant {
+	if s.tenantService == nil {
+		return nil
+	}
+	settings, err := s.tenantService.GetTenant(tenant)
+	if err != nil {
+		log.Printf("Error loading tenant %s: %v", tenant, err)
+		return nil
+	}
+	return settings
+}
+
+// TenantEnabled reports whether tenant exists and may use the chat server
+func (s *Hub) TenantEnabled(tenant string) bool {
+	settings := s.tenantSettings(tenant)
+	return settings != nil && !settings.Disabled
+}
+
+// IsAdmin reports whether userID may send broadcasts to their tenant over
+// the websocket. CHAT_ADMIN_USERS are admins of the default tenant.
+func (s *Hub) IsAdmin(tenant, userID string) bool {
+	if tenant == models.DefaultTenant {
+		for _, admin := range config.AppConfig.AdminUsers {
+			if admin == userID {
+				return true
+			}
+		}
+	}
+	settings := s.tenantSettings(tenant)
+	return settings != nil && settings.AdminUsers.Contains(userID)
+}
+
+// historyLimit is how many messages are replayed to a tenant's new connections
+func (s *Hub) historyLimit(tenant string) int {
+	if settings := s.tenantSettings(tenant); settings != nil && settings.HistoryLimit > 0 {
+		return settings.HistoryLimit
+	}
+	return config.AppConfig.HistoryLimit
+}
+
+// maxConnections is how many connections a tenant may hold on this node, 0 is unlimited
+func (s *Hub) maxConnections(tenant string) int {
+	if settings := s.tenantSettings(tenant); settings != nil {
+		return settings.MaxConnections
+	}
+	return 0
+}
+
+// allowMessage counts a message against its tenant's per-minute limit and
+// reports whether it may be sent. Counting is shared through Redis so the
+// limit holds across the cluster.
+func (s *Hub) allowMessage(tenant string) bool {
+	settings := s.tenantSettings(tenant)
+	if settings == nil || settings.MessagesPerMinute <= 0 || s.rdb == nil {
+		return true
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	minute := time.Now().Unix() / 60
+	key := rateLimitPrefix + tenant + ":" + strconv.FormatInt(minute, 10)
+	count, err := s.rdb.Incr(ctx, key).Result()
+	if err != nil {
+		// Prefer letting messages through over failing closed
+		log.Printf("Error counting messages for tenant %s: %v", tenant, err)
+		return true
+	}
+	if count == 1 {
+		s.rdb.Expire(ctx, key, 2*time.Minute)
+	}
+	return count <= int64(settings.MessagesPerMinute)
+}
@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"chatsystem/internal/models"
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMessageOnlyReachesSenderTenant(t *testing.T) {
+	nodes := newTestCluster(t, "node-a", "node-b")
+	alice := connectIn(nodes[0], "acme", "alice", "phone")
+	acmeBob := connectIn(nodes[1], "acme", "bob", "laptop")
+	globexBob := connectIn(nodes[0], "globex", "bob", "laptop")
+
+	nodes[0].Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "hi", Type: "chat", DeviceID: "phone"})
+
+	if got := next(t, acmeBob, "chat"); got.Text != "hi" || got.Tenant != "acme" {
+		t.Errorf("acme bob got %+v", got)
+	}
+	none(t, globexBob, "chat")
+}
+
+func TestOfflineQueuesAreKeptPerTenant(t *testing.T) {
+	hub := newTestHub(t)
+	alice := connectIn(hub, "acme", "alice", "phone")
+	hub.Submit(alice, models.Message{Sender: "alice", Receiver: "bob", Text: "while you were out", Type: "chat", DeviceID: "phone"})
+	next(t, alice, "ack")
+
+	key := offlineQueueKey(UserKey("acme", "bob"))
+	deadline := time.Now().Add(2 * time.Second)
+	for hub.rdb.LLen(context.Background(), key).Val() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("message never reached acme bob's offline queue")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	globexBob := connectIn(hub, "globex", "bob", "laptop")
+	hub.FlushOfflineMessages(globexBob)
+	none(t, globexBob, "chat")
+
+	acmeBob := connectIn(hub, "acme", "bob", "laptop")
+	hub.FlushOfflineMessages(acmeBob)
+	if got := next(t, acmeBob, "chat"); got.Text != "while you were out" {
+		t.Errorf("acme bob got %+v", got)
+	}
+}
+
+func TestPresenceIsKeptPerTenant(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+	connectIn(hub, "acme", "bob", "laptop")
+	connectIn(hub, "globex", "bob", "laptop")
+
+	hub.unregisterPresence(UserKey("globex", "bob"))
+
+	if nodes := hub.rdb.SMembers(ctx, presenceKey(UserKey("acme", "bob"))).Val(); len(nodes) != 1 || nodes[0] != hub.nodeID {
+		t.Errorf("acme bob is on %v, want [%s]", nodes, hub.nodeID)
+	}
+	if nodes := hub.rdb.SMembers(ctx, presenceKey(UserKey("globex", "bob"))).Val(); len(nodes) != 0 {
+		t.Errorf("globex bob is still on %v", nodes)
+	}
+}
+
+func TestIdempotencyKeysArePerTenant(t *testing.T) {
+	hub := newTestHub(t)
+	acmeBob := connectIn(hub, "acme", "bob", "laptop")
+	globexBob := connectIn(hub, "globex", "bob", "laptop")
+	acmeAlice := connectIn(hub, "acme", "alice", "phone")
+	globexAlice := connectIn(hub, "globex", "alice", "phone")
+
+	hub.Submit(acmeBob, models.Message{Sender: "bob", Receiver: "alice", Text: "from acme", Type: "chat", DeviceID: "laptop", IdempotencyKey: "k1"})
+	acmeID := next(t, acmeBob, "ack").MessageID
+	hub.Submit(globexBob, models.Message{Sender: "bob", Receiver: "alice", Text: "from globex", Type: "chat", DeviceID: "laptop", IdempotencyKey: "k1"})
+	globexID := next(t, globexBob, "ack").MessageID
+
+	if acmeID == globexID {
+		t.Fatalf("globex bob's message was taken for a retry of acme bob's %s", acmeID)
+	}
+	if got := next(t, acmeAlice, "chat"); got.Text != "from acme" {
+		t.Errorf("acme alice got %+v", got)
+	}
+	if got := next(t, globexAlice, "chat"); got.Text != "from globex" {
+		t.Errorf("globex alice got %+v", got)
+	}
+}
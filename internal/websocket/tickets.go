@@ -0,0 +1,76 @@
+package websocket
+
+import (
+	"chatsystem/internal/auth"
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const ticketPrefix = "chat:ticket:"
+
+var (
+	ErrInvalidTicket = errors.New("invalid or expired ticket")
+	errNoTicketStore = errors.New("tickets need redis")
+)
+
+// ticketGrant is who a ticket lets its holder connect as, and with which scopes
+type ticketGrant struct {
+	Tenant string        `json:"tenant"`
+	User   string        `json:"user"`
+	Scopes models.Scopes `json:"scopes"`
+}
+
+// IssueTicket stores a single-use ticket that lets a tenant's user open one
+// websocket connection within WSTicketTTL, holding the end-user part of
+// scopes. Browsers can't send headers on the upgrade, so the ticket travels
+// as ?ticket= instead of a long-lived credential.
+func (s *Hub) IssueTicket(ctx context.Context, tenant, userID string, scopes models.Scopes) (string, time.Time, error) {
+	if s.rdb == nil {
+		return "", time.Time{}, errNoTicketStore
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	ticket := hex.EncodeToString(raw)
+
+	grant, err := json.Marshal(ticketGrant{Tenant: tenant, User: userID, Scopes: scopes.Delegated()})
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	ttl := config.AppConfig.WSTicketTTL
+	if err := s.rdb.Set(ctx, ticketPrefix+ticket, grant, ttl).Err(); err != nil {
+		return "", time.Time{}, err
+	}
+	return ticket, time.Now().Add(ttl), nil
+}
+
+// RedeemTicket consumes a ticket and returns who it was issued to. GETDEL
+// makes redemption atomic, so a ticket can't open two connections even when
+// they race on different nodes.
+func (s *Hub) RedeemTicket(ctx context.Context, ticket string) (*auth.Identity, error) {
+	if s.rdb == nil {
+		return nil, errNoTicketStore
+	}
+	raw, err := s.rdb.GetDel(ctx, ticketPrefix+ticket).Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, ErrInvalidTicket
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Tickets from before scopes hold a bare user key, let them expire
+	var grant ticketGrant
+	if err := json.Unmarshal([]byte(raw), &grant); err != nil || grant.Tenant == "" || grant.User == "" {
+		return nil, ErrInvalidTicket
+	}
+	return &auth.Identity{Tenant: grant.Tenant, User: grant.User, Scopes: grant.Scopes.Delegated()}, nil
+}
@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"chatsystem/internal/config"
+	"chatsystem/internal/models"
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestTicketOpensOneConnection(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+	ticket, _, err := hub.IssueTicket(ctx, "acme", "alice", models.Scopes{models.ScopeSend})
+	if err != nil {
+		t.Fatalf("issuing ticket: %v", err)
+	}
+
+	identity, err := hub.RedeemTicket(ctx, ticket)
+	if err != nil {
+		t.Fatalf("redeeming ticket: %v", err)
+	}
+	if identity.Tenant != "acme" || identity.User != "alice" || !reflect.DeepEqual(identity.Scopes, models.Scopes{models.ScopeSend}) {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	if _, err := hub.RedeemTicket(ctx, ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("second redemption err = %v, want ErrInvalidTicket", err)
+	}
+}
+
+func TestTicketExpires(t *testing.T) {
+	server := miniredis.RunT(t)
+	rdb := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { rdb.Close() })
+	hub := newTestNode(t, newTestDB(t), rdb, config.AppConfig.NodeID)
+
+	ctx := context.Background()
+	ticket, _, err := hub.IssueTicket(ctx, models.DefaultTenant, "alice", models.UserScopes)
+	if err != nil {
+		t.Fatalf("issuing ticket: %v", err)
+	}
+	server.FastForward(config.AppConfig.WSTicketTTL)
+
+	if _, err := hub.RedeemTicket(ctx, ticket); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("err = %v, want ErrInvalidTicket", err)
+	}
+}
+
+func TestTicketOnlyCarriesUserScopes(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name   string
+		scopes models.Scopes
+		want   models.Scopes
+	}{
+		{"admin key", models.Scopes{models.ScopeAdmin}, models.UserScopes},
+		{"token issuer", models.Scopes{models.ScopeIssueTokens, models.ScopeSend}, models.Scopes{models.ScopeSend}},
+		{"no scopes", nil, models.Scopes{}},
+	}
+	for _, tt := range tests {
+		ticket, _, err := hub.IssueTicket(ctx, models.DefaultTenant, "alice", tt.scopes)
+		if err != nil {
+			t.Fatalf("%s: issuing ticket: %v", tt.name, err)
+		}
+		identity, err := hub.RedeemTicket(ctx, ticket)
+		if err != nil {
+			t.Fatalf("%s: redeeming ticket: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(identity.Scopes, tt.want) {
+			t.Errorf("%s: scopes = %v, want %v", tt.name, identity.Scopes, tt.want)
+		}
+	}
+}
+
+func TestTicketWithoutGrantIsInvalid(t *testing.T) {
+	hub := newTestHub(t)
+	ctx := context.Background()
+	// Tickets issued before scopes held a bare user key
+	hub.rdb.Set(ctx, ticketPrefix+"old", UserKey(models.DefaultTenant, "alice"), config.AppConfig.WSTicketTTL)
+
+	if _, err := hub.RedeemTicket(ctx, "old"); !errors.Is(err, ErrInvalidTicket) {
+		t.Errorf("err = %v, want ErrInvalidTicket", err)
+	}
+}
@@ -1,10 +1,13 @@
 package websocket
 
 import (
+	"chatsystem/internal/auth"
 	"chatsystem/internal/models"
+	"chatsystem/internal/services"
 	"context"
 	"fmt"
 	"log"
+	"net/http"
 	"sync"
 	"time"
 
@@ -13,6 +16,7 @@ import (
 
 type ChatClient struct {
 	conn      *websocket.Conn
+	tenant    string
 	userID    string
 	sendCh    chan models.Message
 	receiveCh chan models.Message
@@ -21,9 +25,10 @@ type ChatClient struct {
 	closed    bool
 }
 
-// NewClient creates a new client instance
-func NewChatClient(userID string) *ChatClient {
+// NewClient creates a new client instance for a tenant's user
+func NewChatClient(tenant, userID string) *ChatClient {
 	return &ChatClient{
+		tenant:    tenant,
 		userID:    userID,
 		sendCh:    make(chan models.Message, 100),
 		receiveCh: make(chan models.Message, 100),
@@ -38,7 +43,15 @@ func (c *ChatClient) ChatConnect(ctx context.Context) error {
 		HandshakeTimeout: 10 * time.Second,
 	}
 
-	conn, _, err := dialer.DialContext(ctx, wsURL, nil)
+	// The bridge is trusted, so it signs its own token for the user it acts for
+	token, _, err := auth.Issue(c.tenant, c.userID, models.Scopes{models.ScopeSend})
+	if err != nil {
+		return fmt.Errorf("failed to issue token: %w", err)
+	}
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+
+	conn, _, err := dialer.DialContext(ctx, wsURL, header)
 	if err != nil {
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
@@ -71,13 +84,21 @@ func (c *ChatClient) ChatRegister() error {
 	}
 }
 
+// SendChatMessage sends a chat message under a fresh idempotency key
 func (c *ChatClient) SendChatMessage(receiver, text string) error {
+	return c.SendChatMessageWithKey(receiver, text, services.NewID())
+}
+
+// SendChatMessageWithKey sends a chat message under the given idempotency
+// key. Retrying with the same key after a timeout will not duplicate it.
+func (c *ChatClient) SendChatMessageWithKey(receiver, text, idempotencyKey string) error {
 	msg := models.Message{
-		Sender:    c.userID,
-		Receiver:  receiver,
-		Text:      text,
-		Type:      "chat",
-		Timestamp: time.Now(),
+		Sender:         c.userID,
+		Receiver:       receiver,
+		Text:           text,
+		Type:           "chat",
+		Timestamp:      time.Now(),
+		IdempotencyKey: idempotencyKey,
 	}
 
 	select {
@@ -194,33 +215,35 @@ func NewClientManager() *ClientManager {
 	}
 }
 
-func (cm *ClientManager) GetOrCreateClient(userID string) *ChatClient {
+func (cm *ClientManager) GetOrCreateClient(tenant, userID string) *ChatClient {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	if client, exists := cm.clients[userID]; exists {
+	key := UserKey(tenant, userID)
+	if client, exists := cm.clients[key]; exists {
 		return client
 	}
 
-	client := NewChatClient(userID)
-	cm.clients[userID] = client
+	client := NewChatClient(tenant, userID)
+	cm.clients[key] = client
 	return client
 }
 
-func (cm *ClientManager) RemoveClient(userID string) {
+func (cm *ClientManager) RemoveClient(tenant, userID string) {
 	cm.mu.Lock()
 	defer cm.mu.Unlock()
 
-	if client, exists := cm.clients[userID]; exists {
+	key := UserKey(tenant, userID)
+	if client, exists := cm.clients[key]; exists {
 		client.Close()
-		delete(cm.clients, userID)
+		delete(cm.clients, key)
 	}
 }
 
-func (cm *ClientManager) GetClient(userID string) (*ChatClient, bool) {
+func (cm *ClientManager) GetClient(tenant, userID string) (*ChatClient, bool) {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	client, exists := cm.clients[userID]
+	client, exists := cm.clients[UserKey(tenant, userID)]
 	return client, exists
 }
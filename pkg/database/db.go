@@ -2,6 +2,7 @@ package database
 
 import (
 	"chatsystem/internal/config"
+	"chatsystem/internal/models"
 	"fmt"
 	"log"
 	"os"
@@ -34,9 +35,7 @@ func ConnectDB() (*gorm.DB, error) {
 	log.Println("⚡️💾 \033[1;32mDatabase ::Connected\033[0m")
 	// Auto Migrate
 	// Auto Migrate with error handling and proper order
-	if err := db.AutoMigrate(
-		// your model schema here
-	); err != nil {
+	if err := db.AutoMigrate(models.All()...); err != nil {
 		log.Printf("Failed to migrate: %v", err)
 		return nil, err
 	}
@@ -0,0 +1,98 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif" // Registers the GIF decoder with image.Decode
+	"image/jpeg"
+	"image/png"
+)
+
+// MaxPixels bounds the images we are willing to decode, so a small file
+// claiming huge dimensions can't exhaust memory. It fits a 12 megapixel
+// photo, which decodes to at most 64MB.
+const MaxPixels = 16 << 20
+
+var (
+	ErrUnsupported = errors.New("unsupported image format")
+	ErrTooLarge    = errors.New("image dimensions too large")
+)
+
+// Image is an uploaded image after processing
+type Image struct {
+	Data          []byte // The original file with location metadata removed
+	Width         int    // Width as displayed, after EXIF orientation
+	Height        int    // Height as displayed, after EXIF orientation
+	Thumbnail     []byte
+	ThumbnailType string
+}
+
+// IsImage reports whether Process can handle files of contentType
+func IsImage(contentType string) bool {
+	switch contentType {
+	case "image/jpeg", "image/png", "image/gif":
+		return true
+	}
+	return false
+}
+
+// Process strips location metadata from a JPEG, PNG or GIF file, measures it
+// and renders a thumbnail whose longest edge is at most thumbEdge pixels
+func Process(contentType string, data []byte, thumbEdge int) (*Image, error) {
+	orientation := 1
+	var err error
+	switch contentType {
+	case "image/jpeg":
+		data, orientation, err = stripJPEGLocation(data)
+	case "image/png":
+		data, err = stripPNGLocation(data)
+	case "image/gif":
+		// GIFs don't carry EXIF
+	default:
+		return nil, ErrUnsupported
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > MaxPixels {
+		return nil, ErrTooLarge
+	}
+
+	// Animated GIFs decode to their first frame, which is what we preview
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	thumb := orient(Thumbnail(src, thumbEdge), orientation)
+
+	result := &Image{
+		Data:   data,
+		Width:  cfg.Width,
+		Height: cfg.Height,
+	}
+	if orientation >= 5 {
+		result.Width, result.Height = cfg.Height, cfg.Width
+	}
+
+	var buf bytes.Buffer
+	if contentType == "image/jpeg" {
+		err = jpeg.Encode(&buf, thumb, &jpeg.Options{Quality: 80})
+		result.ThumbnailType = "image/jpeg"
+	} else {
+		// PNG keeps the transparency PNGs and GIFs may have
+		err = png.Encode(&buf, thumb)
+		result.ThumbnailType = "image/png"
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode thumbnail: %w", err)
+	}
+	result.Thumbnail = buf.Bytes()
+	return result, nil
+}
@@ -0,0 +1,206 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrMalformed = errors.New("malformed image")
+
+const (
+	tagOrientation = 0x0112
+	tagGPSInfo     = 0x8825
+)
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	pngMagic   = []byte("\x89PNG\r\n\x1a\n")
+)
+
+// stripJPEGLocation blanks the GPS IFD of the EXIF block and drops XMP packets,
+// which can repeat the location. The rest of the EXIF block is kept, and the
+// orientation it records is returned so previews can be rotated to match.
+func stripJPEGLocation(data []byte) ([]byte, int, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, 0, ErrMalformed
+	}
+
+	orientation := 1
+	out := make([]byte, 0, len(data))
+	out = append(out, data[:2]...)
+	i := 2
+	for i < len(data) {
+		if data[i] != 0xFF || i+1 >= len(data) {
+			return nil, 0, ErrMalformed
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			// Fill byte before a marker
+			i++
+			continue
+		case marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			// Markers without a length
+			out = append(out, data[i:i+2]...)
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// Metadata only appears before the scan data, copy the rest untouched
+			return append(out, data[i:]...), orientation, nil
+		}
+
+		if i+4 > len(data) {
+			return nil, 0, ErrMalformed
+		}
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return nil, 0, ErrMalformed
+		}
+		segment := data[i:end]
+		payload := segment[4:]
+		if marker == 0xE1 {
+			if bytes.HasPrefix(payload, xmpHeader) {
+				i = end
+				continue
+			}
+			if bytes.HasPrefix(payload, exifHeader) {
+				start := len(out) + 4 + len(exifHeader)
+				out = append(out, segment...)
+				orientation = scrubEXIF(out[start:])
+				i = end
+				continue
+			}
+		}
+		out = append(out, segment...)
+		i = end
+	}
+	return out, orientation, nil
+}
+
+// stripPNGLocation drops the eXIf chunk and XMP packets from a PNG
+func stripPNGLocation(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, pngMagic) {
+		return nil, ErrMalformed
+	}
+
+	out := make([]byte, 0, len(data))
+	out = append(out, pngMagic...)
+	i := len(pngMagic)
+	for i < len(data) {
+		if i+8 > len(data) {
+			return nil, ErrMalformed
+		}
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if end > len(data) || end < i {
+			return nil, ErrMalformed
+		}
+		kind := string(data[i+4 : i+8])
+		body := data[i+8 : i+8+length]
+
+		drop := kind == "eXIf" ||
+			(kind == "iTXt" && bytes.HasPrefix(body, []byte("XML:com.adobe.xmp\x00")))
+		if !drop {
+			out = append(out, data[i:end]...)
+		}
+		i = end
+		if kind == "IEND" {
+			break
+		}
+	}
+	return out, nil
+}
+
+// scrubEXIF empties the GPS IFD of a TIFF-structured EXIF block in place and
+// returns the image orientation recorded in IFD0
+func scrubEXIF(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 1
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 1
+	}
+	if order.Uint16(tiff[2:]) != 42 {
+		return 1
+	}
+
+	orientation := 1
+	var gps uint32
+	ifd0 := uint64(order.Uint32(tiff[4:]))
+	forEachEntry(tiff, order, ifd0, func(entry []byte) {
+		switch order.Uint16(entry) {
+		case tagOrientation:
+			if o := int(order.Uint16(entry[8:])); o >= 1 && o <= 8 {
+				orientation = o
+			}
+		case tagGPSInfo:
+			gps = order.Uint32(entry[8:])
+		}
+	})
+
+	if gps != 0 {
+		offset := uint64(gps)
+		count := 0
+		forEachEntry(tiff, order, offset, func(entry []byte) {
+			count++
+			// Values over four bytes live elsewhere in the block
+			size := typeSize(order.Uint16(entry[2:])) * uint64(order.Uint32(entry[4:]))
+			if size > 4 {
+				clear(span(tiff, uint64(order.Uint32(entry[8:])), size))
+			}
+		})
+		// An empty IFD: zero entries followed by a zero next-IFD offset
+		entries := 2 + 12*uint64(count)
+		clear(span(tiff, offset, entries))
+		clear(span(tiff, offset+entries, 4))
+	}
+	return orientation
+}
+
+// forEachEntry calls fn with each 12 byte entry of the IFD at offset
+func forEachEntry(tiff []byte, order binary.ByteOrder, offset uint64, fn func(entry []byte)) {
+	header := span(tiff, offset, 2)
+	if len(header) < 2 {
+		return
+	}
+	count := uint64(order.Uint16(header))
+	for n := uint64(0); n < count; n++ {
+		entry := span(tiff, offset+2+12*n, 12)
+		if len(entry) < 12 {
+			return
+		}
+		fn(entry)
+	}
+}
+
+// span returns b[offset:offset+n], or nil if that runs past the end of b
+func span(b []byte, offset, n uint64) []byte {
+	end := offset + n
+	if end > uint64(len(b)) || end < offset {
+		return nil
+	}
+	return b[offset:end]
+}
+
+// typeSize returns the size in bytes of one value of a TIFF field type
+func typeSize(kind uint16) uint64 {
+	switch kind {
+	case 1, 2, 6, 7: // BYTE, ASCII, SBYTE, UNDEFINED
+		return 1
+	case 3, 8: // SHORT, SSHORT
+		return 2
+	case 4, 9, 11: // LONG, SLONG, FLOAT
+		return 4
+	case 5, 10, 12: // RATIONAL, SRATIONAL, DOUBLE
+		return 8
+	}
+	return 0
+}
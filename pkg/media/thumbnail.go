@@ -0,0 +1,97 @@
+package media
+
+import (
+	"image"
+	"image/draw"
+)
+
+// Thumbnail scales src down so its longest edge is at most maxEdge pixels,
+// averaging every source pixel that falls under each thumbnail pixel. Images
+// that already fit are copied unscaled. Large images are converted one row
+// at a time, so scaling never holds a second full-size copy.
+func Thumbnail(src image.Image, maxEdge int) *image.RGBA {
+	bounds := src.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	if maxEdge <= 0 || (w <= maxEdge && h <= maxEdge) {
+		rgba := image.NewRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+		return rgba
+	}
+
+	dw, dh := maxEdge, max(1, (h*maxEdge+w/2)/w)
+	if h > w {
+		dw, dh = max(1, (w*maxEdge+h/2)/h), maxEdge
+	}
+
+	// row holds one source row as RGBA, sums the channel totals of the
+	// thumbnail row being built
+	row := image.NewRGBA(image.Rect(0, 0, w, 1))
+	sums := make([]uint64, dw*4)
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*h/dh, max((dy+1)*h/dh, dy*h/dh+1)
+		clear(sums)
+		for y := y0; y < y1; y++ {
+			draw.Draw(row, row.Bounds(), src, image.Pt(bounds.Min.X, bounds.Min.Y+y), draw.Src)
+			for dx := 0; dx < dw; dx++ {
+				x0, x1 := dx*w/dw, max((dx+1)*w/dw, dx*w/dw+1)
+				sum := sums[dx*4 : dx*4+4]
+				for i := x0 * 4; i < x1*4; i += 4 {
+					sum[0] += uint64(row.Pix[i])
+					sum[1] += uint64(row.Pix[i+1])
+					sum[2] += uint64(row.Pix[i+2])
+					sum[3] += uint64(row.Pix[i+3])
+				}
+			}
+		}
+
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*w/dw, max((dx+1)*w/dw, dx*w/dw+1)
+			n := uint64((x1 - x0) * (y1 - y0))
+			offset := dst.PixOffset(dx, dy)
+			for c := 0; c < 4; c++ {
+				dst.Pix[offset+c] = uint8(sums[dx*4+c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+// orient applies an EXIF orientation so the image displays upright
+func orient(src *image.RGBA, orientation int) *image.RGBA {
+	if orientation <= 1 || orientation > 8 {
+		return src
+	}
+
+	w, h := src.Bounds().Dx(), src.Bounds().Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := 0; y < dh; y++ {
+		for x := 0; x < dw; x++ {
+			// Where this pixel comes from in the stored image
+			var sx, sy int
+			switch orientation {
+			case 2: // Mirrored
+				sx, sy = w-1-x, y
+			case 3: // Upside down
+				sx, sy = w-1-x, h-1-y
+			case 4: // Upside down and mirrored
+				sx, sy = x, h-1-y
+			case 5: // Transposed
+				sx, sy = y, x
+			case 6: // Needs a quarter turn clockwise
+				sx, sy = y, h-1-x
+			case 7: // Transverse
+				sx, sy = w-1-y, h-1-x
+			case 8: // Needs a quarter turn anticlockwise
+				sx, sy = w-1-y, x
+			}
+			copy(dst.Pix[dst.PixOffset(x, y):dst.PixOffset(x, y)+4], src.Pix[src.PixOffset(sx, sy):src.PixOffset(sx, sy)+4])
+		}
+	}
+	return dst
+}
@@ -0,0 +1,116 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math/rand"
+	"testing"
+)
+
+// boxAverage is the straightforward thumbnail: convert the whole image,
+// then average each block
+func boxAverage(src image.Image, dw, dh int) *image.RGBA {
+	bounds := src.Bounds()
+	rgba := image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(rgba, rgba.Bounds(), src, bounds.Min, draw.Src)
+
+	w, h := bounds.Dx(), bounds.Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for dy := 0; dy < dh; dy++ {
+		y0, y1 := dy*h/dh, max((dy+1)*h/dh, dy*h/dh+1)
+		for dx := 0; dx < dw; dx++ {
+			x0, x1 := dx*w/dw, max((dx+1)*w/dw, dx*w/dw+1)
+			var sum [4]uint64
+			for y := y0; y < y1; y++ {
+				for x := x0; x < x1; x++ {
+					for c := 0; c < 4; c++ {
+						sum[c] += uint64(rgba.Pix[rgba.PixOffset(x, y)+c])
+					}
+				}
+			}
+			n := uint64((x1 - x0) * (y1 - y0))
+			for c := 0; c < 4; c++ {
+				dst.Pix[dst.PixOffset(dx, dy)+c] = uint8(sum[c] / n)
+			}
+		}
+	}
+	return dst
+}
+
+func noise(img draw.Image, seed int64) {
+	rng := rand.New(rand.NewSource(seed))
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			img.Set(x, y, color.NRGBA{uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256)), uint8(rng.Intn(256))})
+		}
+	}
+}
+
+func TestThumbnailAveragesBlocks(t *testing.T) {
+	nrgba := image.NewNRGBA(image.Rect(3, 5, 403, 305))
+	noise(nrgba, 1)
+
+	ycbcr := image.NewYCbCr(image.Rect(0, 0, 333, 517), image.YCbCrSubsampleRatio420)
+	rng := rand.New(rand.NewSource(2))
+	rng.Read(ycbcr.Y)
+	rng.Read(ycbcr.Cb)
+	rng.Read(ycbcr.Cr)
+
+	paletted := image.NewPaletted(image.Rect(0, 0, 250, 90), color.Palette{color.Black, color.White, color.Transparent})
+	for i := range paletted.Pix {
+		paletted.Pix[i] = uint8(rng.Intn(3))
+	}
+
+	for name, src := range map[string]image.Image{"nrgba": nrgba, "ycbcr": ycbcr, "paletted": paletted} {
+		thumb := Thumbnail(src, 64)
+		want := boxAverage(src, thumb.Bounds().Dx(), thumb.Bounds().Dy())
+		if max(thumb.Bounds().Dx(), thumb.Bounds().Dy()) != 64 {
+			t.Errorf("%s: thumbnail is %v, want a 64 pixel longest edge", name, thumb.Bounds())
+		}
+		if !bytes.Equal(thumb.Pix, want.Pix) {
+			t.Errorf("%s: thumbnail differs from the block average", name)
+		}
+	}
+}
+
+func TestThumbnailKeepsSmallImages(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 30))
+	noise(src, 3)
+	if thumb := Thumbnail(src, 64); thumb.Bounds() != image.Rect(0, 0, 40, 30) {
+		t.Errorf("small image was resized to %v", thumb.Bounds())
+	}
+}
+
+// withDimensions rewrites a PNG's header to claim the given size
+func withDimensions(t *testing.T, data []byte, width, height uint32) []byte {
+	t.Helper()
+	data = bytes.Clone(data)
+	// Signature (8), IHDR length (4) and type (4), then width and height
+	ihdr := data[12 : 12+4+13]
+	binary.BigEndian.PutUint32(ihdr[4:], width)
+	binary.BigEndian.PutUint32(ihdr[8:], height)
+	binary.BigEndian.PutUint32(data[12+4+13:], crc32.ChecksumIEEE(ihdr))
+	return data
+}
+
+func TestProcessRejectsHugeDimensions(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 8, 8))); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Process("image/png", buf.Bytes(), 64); err != nil {
+		t.Fatalf("Process(8x8): %v", err)
+	}
+	huge := withDimensions(t, buf.Bytes(), 5000, 5000)
+	if _, err := Process("image/png", huge, 64); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Process(5000x5000) = %v, want ErrTooLarge", err)
+	}
+}
@@ -0,0 +1,44 @@
+package storage
+
+import (
+	"chatsystem/internal/config"
+	"context"
+	"fmt"
+	"io"
+	"log"
+)
+
+// BlobStore persists uploaded files and returns the URL they are served
+// from, or "" when the store doesn't serve them itself
+type BlobStore interface {
+	Put(ctx context.Context, key, contentType string, body io.Reader) (string, error)
+}
+
+// BlobReader is implemented by stores that don't serve blobs publicly. The
+// API reads their blobs back for callers allowed to see them.
+type BlobReader interface {
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+}
+
+// ConnectBlobStore builds the blob store selected by BLOB_STORE
+func ConnectBlobStore() (BlobStore, error) {
+	switch config.AppConfig.BlobStore {
+	case "cloudinary":
+		store := NewCloudinaryStore(
+			config.AppConfig.CloudinaryCloudName,
+			config.AppConfig.CloudinaryAPIKey,
+			config.AppConfig.CloudinaryAPISecret,
+		)
+		log.Println("🗂️ \033[1;32mCloudinary Blob Store ::Ready\033[0m")
+		return store, nil
+	case "local":
+		store, err := NewLocalStore(config.AppConfig.UploadDir)
+		if err != nil {
+			return nil, err
+		}
+		log.Println("🗂️ \033[1;32mLocal Blob Store ::Ready\033[0m")
+		return store, nil
+	default:
+		return nil, fmt.Errorf("unknown blob store %q", config.AppConfig.BlobStore)
+	}
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CloudinaryStore uploads blobs to Cloudinary with signed uploads
+type CloudinaryStore struct {
+	cloudName string
+	apiKey    string
+	apiSecret string
+	client    *http.Client
+}
+
+// NewCloudinaryStore creates a store for the given Cloudinary account
+func NewCloudinaryStore(cloudName, apiKey, apiSecret string) *CloudinaryStore {
+	return &CloudinaryStore{
+		cloudName: cloudName,
+		apiKey:    apiKey,
+		apiSecret: apiSecret,
+		client:    &http.Client{Timeout: 2 * time.Minute},
+	}
+}
+
+// Put uploads body under key as its public ID and returns the secure URL
+func (s *CloudinaryStore) Put(ctx context.Context, key, contentType string, body io.Reader) (string, error) {
+	// Cloudinary adds the extension itself
+	publicID := strings.TrimSuffix(key, path.Ext(key))
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	signature := s.sign(map[string]string{"public_id": publicID, "timestamp": timestamp})
+
+	// Stream the multipart body instead of buffering the whole file
+	reader, writer := io.Pipe()
+	form := multipart.NewWriter(writer)
+	go func() {
+		err := func() error {
+			fields := map[string]string{
+				"api_key":   s.apiKey,
+				"public_id": publicID,
+				"timestamp": timestamp,
+				"signature": signature,
+			}
+			for name, value := range fields {
+				if err := form.WriteField(name, value); err != nil {
+					return err
+				}
+			}
+			part, err := form.CreateFormFile("file", key)
+			if err != nil {
+				return err
+			}
+			if _, err := io.Copy(part, body); err != nil {
+				return err
+			}
+			return form.Close()
+		}()
+		writer.CloseWithError(err)
+	}()
+
+	endpoint := fmt.Sprintf("https://api.cloudinary.com/v1_1/%s/auto/upload", s.cloudName)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, reader)
+	if err != nil {
+		reader.Close()
+		return "", err
+	}
+	req.Header.Set("Content-Type", form.FormDataContentType())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cloudinary upload failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		SecureURL string `json:"secure_url"`
+		Error     struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("cloudinary upload failed: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cloudinary upload failed: %s", result.Error.Message)
+	}
+	return result.SecureURL, nil
+}
+
+// sign computes the upload signature: SHA-1 of the sorted parameters followed by the API secret
+func (s *CloudinaryStore) sign(params map[string]string) string {
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, key := range keys {
+		pairs = append(pairs, key+"="+params[key])
+	}
+	sum := sha1.Sum([]byte(strings.Join(pairs, "&") + s.apiSecret))
+	return hex.EncodeToString(sum[:])
+}